@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"SystemDynamicsBackend/models"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+type CreateDimensionRequest struct {
+	Name      string   `json:"name" validate:"required"`
+	Elements  []string `json:"elements" validate:"required,min=1,dive,required"`
+	ProjectID uint     `json:"project_id" validate:"required"`
+}
+
+type UpdateDimensionRequest struct {
+	Name     string   `json:"name" validate:"required"`
+	Elements []string `json:"elements" validate:"required,min=1,dive,required"`
+}
+
+// checkDimensionElements rejects repeated elements and elements that could not be written
+// as a subscript, e.g. Population[North].
+func checkDimensionElements(elements []string) error {
+	seen := map[string]bool{}
+	for _, e := range elements {
+		if strings.ContainsAny(e, "[],!") || strings.TrimSpace(e) != e {
+			return invalidField("elements", "subscript", fmt.Errorf("element %q must not contain [ ] , or ! or start or end with a space", e))
+		}
+		if seen[e] {
+			return invalidField("elements", "unique", fmt.Errorf("elements has %q more than once", e))
+		}
+		seen[e] = true
+	}
+	return nil
+}
+
+// checkDimensionName checks that name can be written before ! in an aggregate, e.g.
+// SUM(Population[Region!]), and that no other dimension of the project has it. id is that of
+// the dimension being saved, 0 for a new one.
+func checkDimensionName(projectID any, id int, name string) error {
+	if strings.ContainsAny(name, "[],!") || strings.TrimSpace(name) != name {
+		return invalidField("name", "name", fmt.Errorf("name %q must not contain [ ] , or ! or start or end with a space", name))
+	}
+	var dimensions []models.Dimension
+	if res := models.GetDimensionsByProjectId(&dimensions, projectID); res.Error != nil {
+		return dbError(res.Error, "Dimension")
+	}
+	for _, d := range dimensions {
+		if d.Name == name && d.ID != id {
+			return invalidField("name", "unique", fmt.Errorf("the project already has a dimension named %q", name))
+		}
+	}
+	return nil
+}
+
+func CreateDimension(ctx *fiber.Ctx) error {
+	req := new(CreateDimensionRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	if err := checkDimensionElements(req.Elements); err != nil {
+		return err
+	}
+
+	if err := checkProject(req.ProjectID); err != nil {
+		return err
+	}
+	if err := checkDimensionName(req.ProjectID, 0, req.Name); err != nil {
+		return err
+	}
+
+	dimension := models.Dimension{
+		Name:      req.Name,
+		Elements:  req.Elements,
+		ProjectID: req.ProjectID,
+	}
+	if res := models.CreateDimension(&dimension); res.Error != nil {
+		return dbError(res.Error, "Dimension")
+	}
+
+	return ctx.JSON(fiber.Map{"success": true, "message": "Dimension Successfully Created", "data": dimension})
+}
+
+func UpdateDimension(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	req := new(UpdateDimensionRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	if err := checkDimensionElements(req.Elements); err != nil {
+		return err
+	}
+
+	var existing models.Dimension
+	if res := models.GetDimension(&existing, id); res.Error != nil {
+		return dbError(res.Error, "Dimension")
+	}
+	if err := checkDimensionName(existing.ProjectID, existing.ID, req.Name); err != nil {
+		return err
+	}
+
+	dimension := models.Dimension{Name: req.Name, Elements: req.Elements}
+	res := models.UpdateDimension(&dimension, id)
+	if res.Error != nil {
+		return dbError(res.Error, "Dimension")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Dimension")
+	}
+
+	return ctx.JSON(fiber.Map{"success": true, "message": "Dimension Successfully Updated"})
+}
+
+func GetDimensions(ctx *fiber.Ctx) error {
+	var dimensions []models.Dimension
+	projectID := ctx.Query("project_id")
+	if projectID != "" {
+		if res := models.GetDimensionsByProjectId(&dimensions, projectID); res.Error != nil {
+			return res.Error
+		}
+	} else {
+		if res := models.GetDimensions(&dimensions); res.Error != nil {
+			return res.Error
+		}
+	}
+
+	return ctx.JSON(fiber.Map{"success": true, "message": "Data Successfully Fetched", "data": dimensions})
+}
+
+func GetDimension(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	var dimension models.Dimension
+	if res := models.GetDimension(&dimension, id); res.Error != nil {
+		return dbError(res.Error, "Dimension")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Successfully Fetched", "data": dimension})
+}
+
+func DeleteDimension(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	res := models.DeleteDimension(id)
+	if res.Error != nil {
+		return dbError(res.Error, "Dimension")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Dimension")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Dimension Successfully Deleted"})
+}
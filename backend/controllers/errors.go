@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"SystemDynamicsBackend/database"
+	"SystemDynamicsBackend/jobs"
+	"SystemDynamicsBackend/simulation"
+	"SystemDynamicsBackend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// Error codes, sent in the code field of every error response so that clients can branch
+// on them instead of on the message.
+const (
+	CodeBadRequest         = "bad_request"         // 400: the body is not valid JSON
+	CodeNotFound           = "not_found"           // 404
+	CodeMethodNotAllowed   = "method_not_allowed"  // 405
+	CodeConflict           = "conflict"            // 409: the change breaks a reference between records
+	CodeValidationFailed   = "validation_failed"   // 422: see fields
+	CodeUndefinedReference = "undefined_reference" // 422: an equation references an unknown element
+	CodeSyntaxError        = "syntax_error"        // 422: an equation cannot be parsed
+	CodeAlgebraicLoop      = "algebraic_loop"      // 422: equations depend on each other in a loop
+	CodeTimeLimitExceeded  = "time_limit_exceeded" // 422: the run took longer than the time limit
+	CodeSimulationFailed   = "simulation_failed"   // 422: any other error in the model or during the run
+	CodeQueueFull          = "queue_full"          // 503: too many simulation jobs are waiting
+	CodeBusy               = "busy"                // 503: no worker became free within the time limit
+	CodeInternal           = "internal_error"      // 500
+)
+
+// FieldError is a request field that failed validation. Rule is the validation rule, e.g.
+// required or gt, and Param its parameter, if any.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// APIError is an error that a handler returns to have ErrorHandler send it with HTTP status
+// Status. Details, if set, is sent as the error field, e.g. the unknown reference of an
+// equation.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+	Details any
+	// cause is the error this one classifies, if any.
+	cause error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+func badRequest(message string) *APIError {
+	return &APIError{Status: fiber.StatusBadRequest, Code: CodeBadRequest, Message: message}
+}
+
+// notFound reports that there is no record of the given kind, e.g. "Stock".
+func notFound(kind string) *APIError {
+	return &APIError{Status: fiber.StatusNotFound, Code: CodeNotFound, Message: kind + " not found"}
+}
+
+func conflict(message string) *APIError {
+	return &APIError{Status: fiber.StatusConflict, Code: CodeConflict, Message: message}
+}
+
+// invalid reports a request that is well-formed but cannot be carried out.
+func invalid(message string) *APIError {
+	return &APIError{Status: fiber.StatusUnprocessableEntity, Code: CodeValidationFailed, Message: message}
+}
+
+// invalidField reports a field that failed a check the validator cannot express.
+func invalidField(field, rule string, err error) *APIError {
+	e := invalid(err.Error())
+	e.Fields = []FieldError{{Field: field, Rule: rule, Message: err.Error()}}
+	return e
+}
+
+// parseBody parses the request body into req and validates it.
+func parseBody(ctx *fiber.Ctx, req any) error {
+	if err := ctx.BodyParser(req); err != nil {
+		return badRequest("Invalid Request Format: " + err.Error())
+	}
+	return validate(req)
+}
+
+// validate checks req against its validate tags and reports every field that fails.
+func validate(req any) error {
+	err := database.VL.Struct(req)
+	var valErrs validator.ValidationErrors
+	if !errors.As(err, &valErrs) {
+		return err
+	}
+	e := invalid("")
+	messages := make([]string, len(valErrs))
+	for i, fe := range valErrs {
+		messages[i] = fieldMessage(fe)
+		e.Fields = append(e.Fields, FieldError{Field: fe.Field(), Rule: fe.Tag(), Param: fe.Param(), Message: messages[i]})
+	}
+	e.Message = strings.Join(messages, "; ")
+	return e
+}
+
+// fieldMessage describes a failed validation rule, e.g. "dt must be greater than 0".
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fe.Field() + " is required"
+	case "min":
+		return fmt.Sprintf("%s must have at least %s items", fe.Field(), fe.Param())
+	case "max":
+		return fmt.Sprintf("%s must have at most %s items", fe.Field(), fe.Param())
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", fe.Field(), fe.Param())
+	case "gte":
+		return fmt.Sprintf("%s must be at least %s", fe.Field(), fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", fe.Field(), strings.ReplaceAll(fe.Param(), " ", ", "))
+	}
+	return fmt.Sprintf("%s failed on %s with value %v", fe.Field(), fe.Tag(), fe.Value())
+}
+
+// dbError classifies an error from the database when reading or writing a record of the
+// given kind: a missing record is not found, and a broken foreign key a conflict.
+func dbError(err error, kind string) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return notFound(kind)
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return conflict(kind + " references a project or stock that does not exist")
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return conflict(kind + " already exists")
+	}
+	return err
+}
+
+// simulationError classifies an error from building or running a model. They are all
+// caused by the model, so they are unprocessable rather than internal.
+func simulationError(err error) error {
+	e := &APIError{Status: fiber.StatusUnprocessableEntity, Code: CodeSimulationFailed, Message: err.Error(), cause: err}
+	var undefined *utils.UndefinedReferenceError
+	var syntax *utils.SyntaxError
+	var cycle *simulation.CycleError
+	switch {
+	case errors.As(err, &undefined):
+		e.Code, e.Details = CodeUndefinedReference, undefined
+	case errors.As(err, &syntax):
+		e.Code, e.Details = CodeSyntaxError, syntax
+	case errors.As(err, &cycle):
+		e.Code, e.Details = CodeAlgebraicLoop, cycle
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Code, e.Message = CodeTimeLimitExceeded, fmt.Sprintf("simulation exceeded the time limit of %s", jobs.Default.Timeout)
+	}
+	return e
+}
+
+// ErrorHandler sends the errors returned by handlers as
+// {"success": false, "code": ..., "message": ..., "fields": [...], "error": {...}},
+// with the status of an *APIError, or of the database or Fiber error, and 500 otherwise.
+func ErrorHandler(ctx *fiber.Ctx, err error) error {
+	e := apiError(err)
+	body := errorBody(e)
+	body["success"] = false
+	return ctx.Status(e.Status).JSON(body)
+}
+
+// apiError classifies err as ErrorHandler does.
+func apiError(err error) *APIError {
+	var e *APIError
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &e):
+	case errors.As(err, &fiberErr):
+		e = &APIError{Status: fiberErr.Code, Code: statusCode(fiberErr.Code), Message: fiberErr.Message}
+	default:
+		if !errors.As(dbError(err, "Record"), &e) {
+			e = &APIError{Status: fiber.StatusInternalServerError, Code: CodeInternal, Message: err.Error()}
+		}
+	}
+	return e
+}
+
+// errorBody describes an error as the response body does, without its success field.
+func errorBody(e *APIError) fiber.Map {
+	body := fiber.Map{"code": e.Code, "message": e.Message}
+	if len(e.Fields) > 0 {
+		body["fields"] = e.Fields
+	}
+	if e.Details != nil {
+		body["error"] = e.Details
+	}
+	return body
+}
+
+// statusCode is the error code for an HTTP status.
+func statusCode(status int) string {
+	switch status {
+	case fiber.StatusNotFound:
+		return CodeNotFound
+	case fiber.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case fiber.StatusConflict:
+		return CodeConflict
+	case fiber.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	}
+	if status < fiber.StatusInternalServerError {
+		return CodeBadRequest
+	}
+	return CodeInternal
+}
@@ -2,94 +2,173 @@ package controllers
 
 import (
 	"SystemDynamicsBackend/models"
+	"SystemDynamicsBackend/utils"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 )
 
+// CreateFlowRequest creates a flow. ProjectID may be left out when the flow connects a
+// stock; the flow then belongs to the project of its stocks. Without a Name the flow is
+// named Flow 1, Flow 2, ..., whichever is free in the project.
 type CreateFlowRequest struct {
-	Name      string `json:"name"`
-	FromStock *uint  `json:"from_stock"`
-	ToStock   *uint  `json:"to_stock"`
+	Name        string   `json:"name"`
+	Equation    string   `json:"equation"`
+	Units       string   `json:"units"`
+	Description string   `json:"description"`
+	FromStock   *uint    `json:"from_stock"`
+	ToStock     *uint    `json:"to_stock"`
+	Dimensions  []string `json:"dimensions"`
+	ProjectID   uint     `json:"project_id"`
 }
 
 type UpdateFlowRequest struct {
-	Name      string `json:"name"`
-	FromStock *uint  `json:"from_stock"`
-	ToStock   *uint  `json:"to_stock"`
+	Name        string   `json:"name"`
+	Equation    string   `json:"equation"`
+	Units       string   `json:"units"`
+	Description string   `json:"description"`
+	FromStock   *uint    `json:"from_stock"`
+	ToStock     *uint    `json:"to_stock"`
+	Dimensions  []string `json:"dimensions" gorm:"serializer:json"`
+}
+
+// flowProject returns the project of the stock a flow drains, or else of the stock it fills.
+func flowProject(fromStock, toStock *uint) (uint, error) {
+	for i, id := range []*uint{fromStock, toStock} {
+		if id == nil {
+			continue
+		}
+		var stock models.Stock
+		if res := models.GetStock(&stock, *id); res.Error != nil {
+			return 0, invalidField([]string{"from_stock", "to_stock"}[i], "exists", fmt.Errorf("stock %d does not exist", *id))
+		}
+		return stock.ProjectID, nil
+	}
+	return 0, invalidField("project_id", "required", fmt.Errorf("project_id is required for a flow that connects no stock"))
+}
+
+// checkFlowStocks checks that the stocks a flow drains and fills exist and belong to its project.
+func checkFlowStocks(projectID uint, fromStock, toStock *uint) error {
+	for i, id := range []*uint{fromStock, toStock} {
+		field := []string{"from_stock", "to_stock"}[i]
+		if id == nil {
+			continue
+		}
+		var stock models.Stock
+		if res := models.GetStock(&stock, *id); res.Error != nil {
+			return invalidField(field, "exists", fmt.Errorf("stock %d does not exist", *id))
+		}
+		if stock.ProjectID != projectID {
+			return invalidField(field, "same_project", fmt.Errorf("stock %d belongs to project %d, not to the flow's project %d", *id, stock.ProjectID, projectID))
+		}
+	}
+	return nil
 }
 
 func CreateFlow(ctx *fiber.Ctx) error {
-	success := true
-	message := "Flow Successfully Created"
 	req := new(CreateFlowRequest)
-	if err := ctx.BodyParser(req); err != nil {
-		success = false
-		message = "Invalid Request Format"
-		return ctx.JSON(fiber.Map{"success": success, "message": message})
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	if _, err := utils.ParseUnit(req.Units); err != nil {
+		return invalidField("units", "unit", err)
+	}
+	if req.ProjectID == 0 {
+		projectID, err := flowProject(req.FromStock, req.ToStock)
+		if err != nil {
+			return err
+		}
+		req.ProjectID = projectID
+	}
+	if err := checkProject(req.ProjectID); err != nil {
+		return err
+	}
+	if err := checkFlowStocks(req.ProjectID, req.FromStock, req.ToStock); err != nil {
+		return err
+	}
+	var err error
+	if req.Name == "" {
+		req.Name, err = uniqueName(req.ProjectID, "Flow")
+	} else {
+		err = checkElementName(req.ProjectID, "flow", 0, req.Name)
+	}
+	if err != nil {
+		return err
 	}
 	flow := models.Flow{
-		Name:      req.Name,
-		FromStock: req.FromStock,
-		ToStock:   req.ToStock,
+		Name:        req.Name,
+		Equation:    req.Equation,
+		Units:       req.Units,
+		Description: req.Description,
+		FromStock:   req.FromStock,
+		ToStock:     req.ToStock,
+		Dimensions:  req.Dimensions,
+		ProjectID:   req.ProjectID,
 	}
 	if res := models.CreateFlow(&flow); res.Error != nil {
-		success = false
-		message = res.Error.Error()
+		return dbError(res.Error, "Flow")
 	}
-	return ctx.JSON(fiber.Map{"success": success, "message": message, "data": flow})
+	return ctx.JSON(fiber.Map{"success": true, "message": "Flow Successfully Created", "data": flow})
 }
 
 func UpdateFlow(ctx *fiber.Ctx) error {
-	success := true
-	message := "Flow Successfully Updated"
 	id := ctx.Params("id")
 	req := new(UpdateFlowRequest)
-	if err := ctx.BodyParser(req); err != nil {
-		success = false
-		message = "Invalid Format"
-		return ctx.JSON(fiber.Map{"success": success, "message": message})
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	if _, err := utils.ParseUnit(req.Units); err != nil {
+		return invalidField("units", "unit", err)
+	}
+	var flow models.Flow
+	if res := models.GetFlow(&flow, id); res.Error != nil {
+		return dbError(res.Error, "Flow")
+	}
+	if err := checkFlowStocks(flow.ProjectID, req.FromStock, req.ToStock); err != nil {
+		return err
 	}
-	res := models.UpdateFlow(req, id)
-	if res.Error != nil || res.RowsAffected == 0 {
-		success = false
-		message = "Failed to update Flow"
+	if req.Name != "" {
+		if err := checkElementName(flow.ProjectID, "flow", flow.ID, req.Name); err != nil {
+			return err
+		}
 	}
-	return ctx.JSON(fiber.Map{"success": success, "message": message})
+	if res := models.UpdateFlow(req, id); res.Error != nil {
+		return dbError(res.Error, "Flow")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Flow Successfully Updated"})
 }
 
 func GetFlows(ctx *fiber.Ctx) error {
-	success := true
-	message := "Data Successfully Fetched"
 	var flows []models.Flow
-	res := models.GetFlows(&flows)
-	if res.Error != nil {
-		success = false
-		message = "Failed to Get data from database"
+	projectID := ctx.Query("project_id")
+	if projectID != "" {
+		if res := models.GetFlowsByProjectId(&flows, projectID); res.Error != nil {
+			return res.Error
+		}
+	} else {
+		if res := models.GetFlows(&flows); res.Error != nil {
+			return res.Error
+		}
 	}
-	return ctx.JSON(fiber.Map{"success": success, "message": message, "data": flows})
+	return ctx.JSON(fiber.Map{"success": true, "message": "Data Successfully Fetched", "data": flows})
 }
 
 func GetFlow(ctx *fiber.Ctx) error {
-	success := true
-	message := "Successfully Fetched"
 	id := ctx.Params("id")
 	var flow models.Flow
-	res := models.GetFlow(&flow, id)
-	if res.Error != nil {
-		success = false
-		message = "Failed to Get data from database"
-		return ctx.JSON(fiber.Map{"success": success, "message": message})
+	if res := models.GetFlow(&flow, id); res.Error != nil {
+		return dbError(res.Error, "Flow")
 	}
-	return ctx.JSON(fiber.Map{"success": success, "message": message, "data": flow})
+	return ctx.JSON(fiber.Map{"success": true, "message": "Successfully Fetched", "data": flow})
 }
 
 func DeleteFlow(ctx *fiber.Ctx) error {
-	success := true
-	message := "Flow Successfully Deleted"
 	id := ctx.Params("id")
 	res := models.DeleteFlow(id)
-	if res.Error != nil || res.RowsAffected == 0 {
-		success = false
-		message = "Failed to Delete Flow"
+	if res.Error != nil {
+		return dbError(res.Error, "Flow")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Flow")
 	}
-	return ctx.JSON(fiber.Map{"success": success, "message": message})
+	return ctx.JSON(fiber.Map{"success": true, "message": "Flow Successfully Deleted"})
 }
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"SystemDynamicsBackend/jobs"
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SubmitSimulation queues a simulation as a job and answers 202 with its ID at once. The
+// request is that of Simulate, and is checked before it is queued; errors in the model are
+// reported by the job. Once the job succeeds, its result holds the ID of the saved run.
+func SubmitSimulation(ctx *fiber.Ctx) error {
+	req, settings, elements, err := prepareSimulation(ctx)
+	if err != nil {
+		return err
+	}
+	job, err := jobs.Default.Submit(func(c context.Context, progress func(done, total int)) (any, error) {
+		run, _, err := simulate(c, req, settings, elements, progress, nil)
+		if err != nil {
+			return nil, err
+		}
+		return fiber.Map{"run_id": run.ID, "seed": settings.Seed}, nil
+	})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		return &APIError{Status: fiber.StatusServiceUnavailable, Code: CodeQueueFull, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{"success": true, "message": "Simulation Queued", "data": jobState(job)})
+}
+
+// GetSimulation reports the status and progress of a simulation job.
+func GetSimulation(ctx *fiber.Ctx) error {
+	job := jobs.Default.Get(ctx.Params("id"))
+	if job == nil {
+		return notFound("Simulation")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Successfully Fetched", "data": jobState(job)})
+}
+
+// CancelSimulation cancels a queued or running simulation job.
+func CancelSimulation(ctx *fiber.Ctx) error {
+	job := jobs.Default.Get(ctx.Params("id"))
+	if job == nil {
+		return notFound("Simulation")
+	}
+	if !job.Cancel() {
+		return conflict("Simulation has already finished")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Simulation Canceled", "data": jobState(job)})
+}
+
+// jobState describes a job: its status, its progress as a percentage of its steps, its
+// result once it has succeeded and its error, in the body of an error response, once it
+// has failed.
+func jobState(job *jobs.Job) fiber.Map {
+	s := job.State()
+	state := fiber.Map{
+		"id":          job.ID,
+		"status":      s.Status,
+		"progress":    s.Progress(),
+		"steps_done":  s.Done,
+		"steps":       s.Total,
+		"created_at":  s.CreatedAt,
+		"started_at":  nil,
+		"finished_at": nil,
+	}
+	if !s.StartedAt.IsZero() {
+		state["started_at"] = s.StartedAt
+	}
+	if !s.FinishedAt.IsZero() {
+		state["finished_at"] = s.FinishedAt
+	}
+	if s.Result != nil {
+		state["result"] = s.Result
+	}
+	if s.Err != nil {
+		state["error"] = errorBody(apiError(s.Err))
+	}
+	return state
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"SystemDynamicsBackend/models"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"sort"
+)
+
+type CreateLookupRequest struct {
+	Name          string               `json:"name" validate:"required"`
+	Points        []models.LookupPoint `json:"points" validate:"required,min=1"`
+	Interpolation string               `json:"interpolation" validate:"omitempty,oneof=linear step"`
+	Extrapolate   bool                 `json:"extrapolate"`
+	ProjectID     uint                 `json:"project_id" validate:"required"`
+}
+
+type UpdateLookupRequest struct {
+	Name          string               `json:"name" validate:"required"`
+	Points        []models.LookupPoint `json:"points" validate:"required,min=1"`
+	Interpolation string               `json:"interpolation" validate:"omitempty,oneof=linear step"`
+	Extrapolate   bool                 `json:"extrapolate"`
+}
+
+// sortLookupPoints orders points by X and rejects two points with the same X.
+func sortLookupPoints(points []models.LookupPoint) error {
+	sort.Slice(points, func(i, j int) bool { return points[i].X < points[j].X })
+	for i := 1; i < len(points); i++ {
+		if points[i].X == points[i-1].X {
+			return invalidField("points", "unique", fmt.Errorf("points has more than one point at x = %g", points[i].X))
+		}
+	}
+	return nil
+}
+
+func CreateLookup(ctx *fiber.Ctx) error {
+	req := new(CreateLookupRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	if err := sortLookupPoints(req.Points); err != nil {
+		return err
+	}
+
+	if err := checkProject(req.ProjectID); err != nil {
+		return err
+	}
+
+	lookup := models.Lookup{
+		Name:          req.Name,
+		Points:        req.Points,
+		Interpolation: req.Interpolation,
+		Extrapolate:   req.Extrapolate,
+		ProjectID:     req.ProjectID,
+	}
+	if res := models.CreateLookup(&lookup); res.Error != nil {
+		return dbError(res.Error, "Lookup")
+	}
+
+	return ctx.JSON(fiber.Map{"success": true, "message": "Lookup Successfully Created", "data": lookup})
+}
+
+func UpdateLookup(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	req := new(UpdateLookupRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	if err := sortLookupPoints(req.Points); err != nil {
+		return err
+	}
+	if req.Interpolation == "" {
+		req.Interpolation = "linear"
+	}
+
+	lookup := models.Lookup{
+		Name:          req.Name,
+		Points:        req.Points,
+		Interpolation: req.Interpolation,
+		Extrapolate:   req.Extrapolate,
+	}
+	res := models.UpdateLookup(&lookup, id)
+	if res.Error != nil {
+		return dbError(res.Error, "Lookup")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Lookup")
+	}
+
+	return ctx.JSON(fiber.Map{"success": true, "message": "Lookup Successfully Updated"})
+}
+
+func GetLookups(ctx *fiber.Ctx) error {
+	var lookups []models.Lookup
+	projectID := ctx.Query("project_id")
+	if projectID != "" {
+		if res := models.GetLookupsByProjectId(&lookups, projectID); res.Error != nil {
+			return res.Error
+		}
+	} else {
+		if res := models.GetLookups(&lookups); res.Error != nil {
+			return res.Error
+		}
+	}
+
+	return ctx.JSON(fiber.Map{"success": true, "message": "Data Successfully Fetched", "data": lookups})
+}
+
+func GetLookup(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	var lookup models.Lookup
+	if res := models.GetLookup(&lookup, id); res.Error != nil {
+		return dbError(res.Error, "Lookup")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Successfully Fetched", "data": lookup})
+}
+
+func DeleteLookup(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	res := models.DeleteLookup(id)
+	if res.Error != nil {
+		return dbError(res.Error, "Lookup")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Lookup")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Lookup Successfully Deleted"})
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// namedElement is a stock, variable, flow or lookup, as far as its name is concerned.
+type namedElement struct {
+	kind string
+	id   int
+	name string
+}
+
+// elementNames lists the stocks, variables, flows and lookups of a project.
+func elementNames(projectID any) ([]namedElement, error) {
+	elements, err := loadElements(projectID)
+	if err != nil {
+		return nil, err
+	}
+	var names []namedElement
+	for _, s := range elements.Stocks {
+		names = append(names, namedElement{"stock", s.ID, s.Name})
+	}
+	for _, v := range elements.Variables {
+		names = append(names, namedElement{"variable", v.ID, v.Name})
+	}
+	for _, f := range elements.Flows {
+		names = append(names, namedElement{"flow", f.ID, f.Name})
+	}
+	for _, l := range elements.Lookups {
+		names = append(names, namedElement{"lookup", l.ID, l.Name})
+	}
+	return names, nil
+}
+
+// checkElementName checks that name can be referenced in an equation as [name] and that no
+// other stock, variable, flow or lookup of the project has it, since equations could not
+// tell them apart. kind and id are those of the element being saved, id 0 for a new one.
+func checkElementName(projectID any, kind string, id int, name string) error {
+	if strings.ContainsAny(name, "[]") || strings.TrimSpace(name) != name {
+		return invalidField("name", "name", fmt.Errorf("name %q must not contain [ or ] or start or end with a space", name))
+	}
+	names, err := elementNames(projectID)
+	if err != nil {
+		return err
+	}
+	for _, e := range names {
+		if e.name == name && (e.kind != kind || e.id != id) {
+			return invalidField("name", "unique", fmt.Errorf("the project already has a %s named %q", e.kind, name))
+		}
+	}
+	return nil
+}
+
+// uniqueName returns the first of "<prefix> 1", "<prefix> 2", ... that no element of the
+// project is named, for elements created without a name.
+func uniqueName(projectID any, prefix string) (string, error) {
+	names, err := elementNames(projectID)
+	if err != nil {
+		return "", err
+	}
+	taken := make(map[string]bool, len(names))
+	for _, e := range names {
+		taken[e.name] = true
+	}
+	for n := 1; ; n++ {
+		if name := fmt.Sprintf("%s %d", prefix, n); !taken[name] {
+			return name, nil
+		}
+	}
+}
@@ -1,40 +1,76 @@
 package controllers
 
 import (
-	"SystemDynamicsBackend/database"
 	"SystemDynamicsBackend/models"
-	"fmt"
-	"github.com/go-playground/validator/v10"
+	"SystemDynamicsBackend/simulation"
+	"SystemDynamicsBackend/utils"
+	"encoding/json"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type CreateProjectRequest struct {
 	Name string `json:"name" validate:"required"`
 }
 
-func CreateProject(ctx *fiber.Ctx) error {
-	success := true
-	message := "Project successfully Created"
-	project := new(CreateProjectRequest)
-	err := ctx.BodyParser(project)
-	if err != nil {
-		success = false
-		message = "Invalid Request Format"
-		return ctx.JSON(fiber.Map{
-			"success": success,
-			"message": message,
-		})
+// UpdateProjectRequest changes the name and settings of a project. Omitted fields keep
+// their current value; a seed of null removes the project's seed.
+type UpdateProjectRequest struct {
+	Name              string       `json:"name"`
+	StartTime         *float64     `json:"start_time"`
+	StopTime          *float64     `json:"stop_time"`
+	DT                *float64     `json:"dt" validate:"omitempty,gt=0"`
+	TimeUnit          *string      `json:"time_unit"`
+	IntegrationMethod *string      `json:"integration_method"`
+	SavePer           *float64     `json:"save_per" validate:"omitempty,gte=0"`
+	Seed              nullableSeed `json:"seed"`
+}
+
+// nullableSeed is the seed of an UpdateProjectRequest. Unlike a pointer, it tells a seed of
+// null, which is Set with a nil Value, from an omitted one.
+type nullableSeed struct {
+	Set   bool
+	Value *int64
+}
+
+func (s *nullableSeed) UnmarshalJSON(data []byte) error {
+	s.Set = true
+	return json.Unmarshal(data, &s.Value)
+}
+
+// checkProject checks that the project content is added to exists and is not soft-deleted.
+func checkProject(projectID any) error {
+	var project models.Project
+	if res := models.GetProject(&project, projectID); res.Error != nil {
+		return dbError(res.Error, "Project")
 	}
+	return nil
+}
 
-	err = database.VL.Struct(project)
+// projectSettings returns the simulation settings stored on a project. Its seed, if any, is
+// left to the caller.
+func projectSettings(p models.Project) (simulation.Settings, error) {
+	method, err := simulation.ParseMethod(p.IntegrationMethod)
 	if err != nil {
-		success = false
-		valErr := err.(validator.ValidationErrors)[0]
-		message = fmt.Sprintf("Field '%s', failed on '%s' with your value '%s'", valErr.Field(), valErr.Tag(), valErr.Value())
-		return ctx.JSON(fiber.Map{
-			"success": success,
-			"message": message,
-		})
+		return simulation.Settings{}, invalidField("integration_method", "method", err)
+	}
+	s := simulation.Settings{
+		StartTime: p.StartTime,
+		StopTime:  p.StopTime,
+		DT:        p.DT,
+		SavePer:   p.SavePer,
+		Method:    method,
+	}
+	if err := s.Validate(); err != nil {
+		return s, invalid(err.Error())
+	}
+	return s, nil
+}
+
+func CreateProject(ctx *fiber.Ctx) error {
+	project := new(CreateProjectRequest)
+	if err := parseBody(ctx, project); err != nil {
+		return err
 	}
 
 	newProject := models.Project{
@@ -43,53 +79,131 @@ func CreateProject(ctx *fiber.Ctx) error {
 
 	res := models.CreateProject(&newProject)
 	if res.Error != nil {
-		success = false
-		message = res.Error.Error()
+		return dbError(res.Error, "Project")
 	}
 
 	return ctx.JSON(fiber.Map{
-		"success": success,
-		"message": message,
+		"success": true,
+		"message": "Project successfully Created",
 		"data":    newProject,
 	})
 }
 
 func GetProjects(ctx *fiber.Ctx) error {
-	success := true
-	message := "Data Successfully Fetched"
 	var projects []models.Project
-	res := models.GetProjects(&projects)
+	var res *gorm.DB
+	if ctx.QueryBool("deleted") {
+		res = models.GetDeletedProjects(&projects)
+	} else {
+		res = models.GetProjects(&projects)
+	}
 	if res.Error != nil {
-		success = false
-		message = "Failed to Get data from database"
+		return res.Error
 	}
 	return ctx.JSON(fiber.Map{
-		"success": success,
-		"message": message,
+		"success": true,
+		"message": "Data Successfully Fetched",
 		"data":    projects,
 	})
 }
 
 func GetProject(ctx *fiber.Ctx) error {
-	success := true
-	message := "Successfully Fetched"
-
 	id := ctx.Params("id")
 
 	var project models.Project
 	res := models.GetProject(&project, id)
 	if res.Error != nil {
-		success = false
-		message = "Failed to Get data from database"
-		return ctx.JSON(fiber.Map{
-			"success": success,
-			"message": message,
-		})
+		return dbError(res.Error, "Project")
 	}
 
 	return ctx.JSON(fiber.Map{
-		"success": success,
-		"message": message,
+		"success": true,
+		"message": "Successfully Fetched",
 		"data":    project,
 	})
 }
+
+func UpdateProject(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	req := new(UpdateProjectRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+
+	var project models.Project
+	if res := models.GetProject(&project, id); res.Error != nil {
+		return dbError(res.Error, "Project")
+	}
+	if req.Name != "" {
+		project.Name = req.Name
+	}
+	if req.StartTime != nil {
+		project.StartTime = *req.StartTime
+	}
+	if req.StopTime != nil {
+		project.StopTime = *req.StopTime
+	}
+	if req.DT != nil {
+		project.DT = *req.DT
+	}
+	if req.TimeUnit != nil {
+		project.TimeUnit = *req.TimeUnit
+	}
+	if req.IntegrationMethod != nil {
+		project.IntegrationMethod = *req.IntegrationMethod
+	}
+	if req.SavePer != nil {
+		project.SavePer = *req.SavePer
+	}
+	if req.Seed.Set {
+		project.Seed = req.Seed.Value
+	}
+	if _, err := projectSettings(project); err != nil {
+		return err
+	}
+	if _, err := utils.ParseUnit(project.TimeUnit); err != nil {
+		return invalidField("time_unit", "unit", err)
+	}
+
+	if res := models.UpdateProject(&project, id); res.Error != nil {
+		return dbError(res.Error, "Project")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Project Successfully Updated", "data": project})
+}
+
+// DeleteProject deletes a project and all of its elements. With ?soft=true the project is
+// only marked deleted, and can be brought back with RestoreProject.
+func DeleteProject(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	if ctx.QueryBool("soft") {
+		res := models.SoftDeleteProject(id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return notFound("Project")
+		}
+		return ctx.JSON(fiber.Map{"success": true, "message": "Project Successfully Soft Deleted"})
+	}
+
+	if err := models.DeleteProject(id); err != nil {
+		return dbError(err, "Project")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Project Successfully Deleted"})
+}
+
+func RestoreProject(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	res := models.RestoreProject(id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var project models.Project
+		if models.GetProject(&project, id).Error == nil {
+			return conflict("Project is not deleted")
+		}
+		return notFound("Project")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Project Successfully Restored"})
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"SystemDynamicsBackend/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+type UpdateRunRequest struct {
+	Label string `json:"label"`
+}
+
+// GetRuns lists saved runs, newest first, without their results. ?project_id= lists the runs
+// of one project.
+func GetRuns(ctx *fiber.Ctx) error {
+	var runs []models.SimulationRun
+	projectID := ctx.Query("project_id")
+	if projectID != "" {
+		if res := models.GetRunsByProjectId(&runs, projectID); res.Error != nil {
+			return res.Error
+		}
+	} else {
+		if res := models.GetRuns(&runs); res.Error != nil {
+			return res.Error
+		}
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Data Successfully Fetched", "data": runs})
+}
+
+// GetRun fetches a saved run together with its results, in the rows POST /simulate returns.
+func GetRun(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	var run models.SimulationRun
+	if res := models.GetRun(&run, id); res.Error != nil {
+		return dbError(res.Error, "Run")
+	}
+	var results []models.RunResult
+	if res := models.GetRunResults(&results, run.ID); res.Error != nil {
+		return res.Error
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Successfully Fetched", "data": fiber.Map{
+		"run":     run,
+		"results": models.ResultRows(results),
+	}})
+}
+
+// UpdateRun changes the label of a run.
+func UpdateRun(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	req := new(UpdateRunRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	res := models.LabelRun(req.Label, id)
+	if res.Error != nil {
+		return dbError(res.Error, "Run")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Run")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Run Successfully Updated"})
+}
+
+func DeleteRun(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	if err := models.DeleteRun(id); err != nil {
+		return dbError(err, "Run")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Run Successfully Deleted"})
+}
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"SystemDynamicsBackend/models"
+	"SystemDynamicsBackend/simulation"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"slices"
+	"strings"
+)
+
+type CreateScenarioRequest struct {
+	Name        string                     `json:"name" validate:"required"`
+	Description string                     `json:"description"`
+	Overrides   map[string]models.Override `json:"overrides"`
+	ProjectID   uint                       `json:"project_id" validate:"required"`
+}
+
+type UpdateScenarioRequest struct {
+	Name        string                     `json:"name" validate:"required"`
+	Description string                     `json:"description"`
+	Overrides   map[string]models.Override `json:"overrides"`
+}
+
+// CompareScenariosRequest runs each of ScenarioIDs, at most 20, and the project without
+// overrides if Baseline is set, with the same settings and seed. Overrides in the request apply to every
+// run, and those of a scenario replace them for the same element. Elements, if given, limits the
+// results to those elements.
+type CompareScenariosRequest struct {
+	SimulateRequest
+	ScenarioIDs []uint   `json:"scenario_ids" validate:"required,min=1,max=20"`
+	Baseline    bool     `json:"baseline"`
+	Elements    []string `json:"elements"`
+}
+
+// checkOverrides checks that overrides can be applied to the elements of a project.
+func checkOverrides(projectID any, overrides map[string]models.Override) error {
+	elements, err := loadElements(projectID)
+	if err != nil {
+		return err
+	}
+	_, err = overrideElements(elements, overrides)
+	return err
+}
+
+// mergeOverrides returns the overrides of base with those of over added, replacing the
+// overrides of base for the same elements.
+func mergeOverrides(base, over map[string]models.Override) map[string]models.Override {
+	merged := make(map[string]models.Override, len(base)+len(over))
+	for name, o := range base {
+		merged[name] = o
+	}
+	for name, o := range over {
+		merged[name] = o
+	}
+	return merged
+}
+
+func CreateScenario(ctx *fiber.Ctx) error {
+	req := new(CreateScenarioRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	var project models.Project
+	if res := models.GetProject(&project, req.ProjectID); res.Error != nil {
+		return dbError(res.Error, "Project")
+	}
+	if err := checkOverrides(req.ProjectID, req.Overrides); err != nil {
+		return err
+	}
+
+	scenario := models.Scenario{
+		Name:        req.Name,
+		Description: req.Description,
+		Overrides:   req.Overrides,
+		ProjectID:   req.ProjectID,
+	}
+	if res := models.CreateScenario(&scenario); res.Error != nil {
+		return dbError(res.Error, "Scenario")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Scenario Successfully Created", "data": scenario})
+}
+
+func UpdateScenario(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	req := new(UpdateScenarioRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	var scenario models.Scenario
+	if res := models.GetScenario(&scenario, id); res.Error != nil {
+		return dbError(res.Error, "Scenario")
+	}
+	if err := checkOverrides(scenario.ProjectID, req.Overrides); err != nil {
+		return err
+	}
+
+	scenario.Name, scenario.Description, scenario.Overrides = req.Name, req.Description, req.Overrides
+	if res := models.UpdateScenario(&scenario, id); res.Error != nil {
+		return dbError(res.Error, "Scenario")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Scenario Successfully Updated", "data": scenario})
+}
+
+func GetScenarios(ctx *fiber.Ctx) error {
+	var scenarios []models.Scenario
+	projectID := ctx.Query("project_id")
+	if projectID != "" {
+		if res := models.GetScenariosByProjectId(&scenarios, projectID); res.Error != nil {
+			return res.Error
+		}
+	} else {
+		if res := models.GetScenarios(&scenarios); res.Error != nil {
+			return res.Error
+		}
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Data Successfully Fetched", "data": scenarios})
+}
+
+func GetScenario(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	var scenario models.Scenario
+	if res := models.GetScenario(&scenario, id); res.Error != nil {
+		return dbError(res.Error, "Scenario")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Successfully Fetched", "data": scenario})
+}
+
+func DeleteScenario(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	res := models.DeleteScenario(id)
+	if res.Error != nil {
+		return dbError(res.Error, "Scenario")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Scenario")
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Scenario Successfully Deleted"})
+}
+
+// RunScenario simulates the project of a scenario with its overrides and saves the run,
+// labelled with the scenario's name unless the request gives a label. The body is optional
+// and takes the settings of SimulateRequest; its overrides are applied over the scenario's.
+func RunScenario(ctx *fiber.Ctx) error {
+	var scenario models.Scenario
+	if res := models.GetScenario(&scenario, ctx.Params("id")); res.Error != nil {
+		return dbError(res.Error, "Scenario")
+	}
+	req := new(SimulateRequest)
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(req); err != nil {
+			return badRequest("Invalid Request Format: " + err.Error())
+		}
+	}
+	req.ProjectID = scenario.ProjectID
+	if err := validate(req); err != nil {
+		return err
+	}
+	if req.Label == "" {
+		req.Label = scenario.Name
+	}
+	scenarioRequest(req, scenario)
+
+	settings, elements, err := req.prepare()
+	if err != nil {
+		return err
+	}
+	c, cancel, err := limitedContext()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	run, results, err := simulate(c, req, settings, elements, nil, nil)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Simulation completed", "data": results, "seed": settings.Seed, "run_id": run.ID})
+}
+
+// scenarioRequest makes req a request for scenario, with the scenario's overrides under
+// those of req.
+func scenarioRequest(req *SimulateRequest, scenario models.Scenario) {
+	id := uint(scenario.ID)
+	req.scenarioID = &id
+	req.Overrides = mergeOverrides(scenario.Overrides, req.Overrides)
+}
+
+// compareRequest returns the request for the run of scenario in a comparison: req with its
+// overrides, common to every run, under those of the scenario, labelled with the scenario's
+// name unless req has a label.
+func compareRequest(req SimulateRequest, scenario models.Scenario) SimulateRequest {
+	id := uint(scenario.ID)
+	req.scenarioID = &id
+	req.Overrides = mergeOverrides(req.Overrides, scenario.Overrides)
+	if req.Label == "" {
+		req.Label = scenario.Name
+	}
+	return req
+}
+
+// CompareScenarios runs scenarios of a project side by side and saves each run. Every run
+// has the settings, including the seed, of the request, so that they differ only by their
+// overrides. The runs are answered in the order of the request, the baseline first. They
+// are made one after the other in a single slot of the job pool, and share one time limit.
+func CompareScenarios(ctx *fiber.Ctx) error {
+	req := new(CompareScenariosRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	var project models.Project
+	if res := models.GetProject(&project, req.ProjectID); res.Error != nil {
+		return dbError(res.Error, "Project")
+	}
+	settings, err := req.settings(project)
+	if err != nil {
+		return err
+	}
+	elements, err := loadElements(req.ProjectID)
+	if err != nil {
+		return err
+	}
+	for _, name := range req.Elements {
+		if !hasElement(elements, name) {
+			return invalidField("elements", "element", fmt.Errorf("there is no stock, variable or flow %s", name))
+		}
+	}
+
+	// Each run gets its own copy of the request, with the scenario's overrides and label.
+	var runs []SimulateRequest
+	var names []string
+	if req.Baseline {
+		run := req.SimulateRequest
+		if run.Label == "" {
+			run.Label = "Baseline"
+		}
+		runs, names = append(runs, run), append(names, "Baseline")
+	}
+	for _, id := range req.ScenarioIDs {
+		var scenario models.Scenario
+		if res := models.GetScenario(&scenario, id); res.Error != nil {
+			return invalidField("scenario_ids", "exists", fmt.Errorf("scenario %d does not exist", id))
+		}
+		if scenario.ProjectID != req.ProjectID {
+			return invalidField("scenario_ids", "same_project", fmt.Errorf("scenario %d belongs to project %d, not to project %d", id, scenario.ProjectID, req.ProjectID))
+		}
+		runs, names = append(runs, compareRequest(req.SimulateRequest, scenario)), append(names, scenario.Name)
+	}
+
+	c, cancel, err := limitedContext()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	compared := make([]fiber.Map, len(runs))
+	for i := range runs {
+		runElements, err := overrideElements(elements, runs[i].Overrides)
+		if err != nil {
+			return err
+		}
+		run, results, err := simulate(c, &runs[i], settings, runElements, nil, nil)
+		if err != nil {
+			return err
+		}
+		results = selectElements(results, req.Elements)
+		var final map[string]float64
+		if len(results) > 0 {
+			final = results[len(results)-1]
+		}
+		compared[i] = fiber.Map{
+			"scenario_id": runs[i].scenarioID,
+			"name":        names[i],
+			"run_id":      run.ID,
+			"overrides":   runs[i].Overrides,
+			"results":     results,
+			"final":       final,
+		}
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": "Scenarios compared", "data": compared, "seed": settings.Seed})
+}
+
+// hasElement reports whether there is a stock, variable or flow with the given name.
+func hasElement(elements simulation.Elements, name string) bool {
+	return slices.ContainsFunc(elements.Stocks, func(s models.Stock) bool { return s.Name == name }) ||
+		slices.ContainsFunc(elements.Variables, func(v models.Variable) bool { return v.Name == name }) ||
+		slices.ContainsFunc(elements.Flows, func(f models.Flow) bool { return f.Name == name })
+}
+
+// selectElements keeps only time and the given elements in rows, or every column if none
+// are given. The instances of a subscripted element are kept with it.
+func selectElements(rows []map[string]float64, names []string) []map[string]float64 {
+	if len(names) == 0 {
+		return rows
+	}
+	selected := make([]map[string]float64, len(rows))
+	for i, row := range rows {
+		selected[i] = map[string]float64{"time": row["time"]}
+		for key, v := range row {
+			base, _, _ := strings.Cut(key, "[")
+			if slices.Contains(names, base) {
+				selected[i][key] = v
+			}
+		}
+	}
+	return selected
+}
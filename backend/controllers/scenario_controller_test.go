@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"SystemDynamicsBackend/models"
+	"reflect"
+	"testing"
+)
+
+// TestOverridePrecedence checks that the overrides of a request apply over those of a
+// scenario when it is run alone, and under them when scenarios are compared.
+func TestOverridePrecedence(t *testing.T) {
+	value := func(v float64) models.Override { return models.Override{Value: &v} }
+	scenario := models.Scenario{ID: 7, Name: "High growth", Overrides: map[string]models.Override{
+		"Growth Rate": value(0.1),
+		"Capacity":    value(500),
+	}}
+	request := func() SimulateRequest {
+		return SimulateRequest{ProjectID: 1, Overrides: map[string]models.Override{
+			"Growth Rate": value(0.01),
+			"Price":       value(3),
+		}}
+	}
+
+	run := request()
+	scenarioRequest(&run, scenario)
+	want := map[string]models.Override{"Growth Rate": value(0.01), "Capacity": value(500), "Price": value(3)}
+	if !reflect.DeepEqual(run.Overrides, want) {
+		t.Errorf("running the scenario with overrides gave %v, want %v", run.Overrides, want)
+	}
+
+	compared := compareRequest(request(), scenario)
+	want = map[string]models.Override{"Growth Rate": value(0.1), "Capacity": value(500), "Price": value(3)}
+	if !reflect.DeepEqual(compared.Overrides, want) {
+		t.Errorf("comparing the scenario with overrides gave %v, want %v", compared.Overrides, want)
+	}
+	if compared.Label != scenario.Name || compared.scenarioID == nil || *compared.scenarioID != 7 {
+		t.Errorf("compared run is labelled %q for scenario %v, want %q and 7", compared.Label, compared.scenarioID, scenario.Name)
+	}
+}
@@ -1,111 +1,245 @@
 package controllers
 
 import (
-	"SystemDynamicsBackend/database"
+	"SystemDynamicsBackend/jobs"
 	"SystemDynamicsBackend/models"
-	"SystemDynamicsBackend/utils"
+	"SystemDynamicsBackend/simulation"
+	"context"
 	"fmt"
-	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"time"
 )
 
-// SimulateRequest represents the simulation input.
+// SimulateRequest represents the simulation input. Omitted settings are taken from the
+// project. StopTime may instead be given as SimStep, in which case the run stops after
+// SimStep steps of DT. Label names the saved run. Overrides, keyed by element name, change
+// elements for this run only.
 type SimulateRequest struct {
-	ProjectID uint `json:"project_id" validate:"required"`
-	SimStep   int  `json:"sim_step" validate:"required"`
+	ProjectID         uint                       `json:"project_id" validate:"required"`
+	Label             string                     `json:"label"`
+	SimStep           int                        `json:"sim_step" validate:"omitempty,gt=0"`
+	StartTime         *float64                   `json:"start_time"`
+	StopTime          *float64                   `json:"stop_time"`
+	DT                *float64                   `json:"dt" validate:"omitempty,gt=0"`
+	SavePer           *float64                   `json:"save_per" validate:"omitempty,gte=0"`
+	IntegrationMethod string                     `json:"integration_method"`
+	Seed              *int64                     `json:"seed"`
+	Overrides         map[string]models.Override `json:"overrides"`
+
+	// scenarioID is the scenario the overrides come from, if any.
+	scenarioID *uint
 }
 
-// Simulate runs a simple discrete simulation for the given project.
+// settings resolves the time bounds, integration method and seed of the requested run,
+// falling back to the project's settings. Without a seed in either, one is picked from the
+// clock; it is returned with the results so the run can be repeated.
+func (r *SimulateRequest) settings(project models.Project) (simulation.Settings, error) {
+	s := simulation.Settings{
+		StartTime: project.StartTime,
+		StopTime:  project.StopTime,
+		DT:        project.DT,
+		SavePer:   project.SavePer,
+	}
+	if r.DT != nil {
+		s.DT = *r.DT
+	}
+	if r.StartTime != nil {
+		s.StartTime = *r.StartTime
+	}
+	switch {
+	case r.StopTime != nil:
+		s.StopTime = *r.StopTime
+	case r.SimStep > 0:
+		s.StopTime = s.StartTime + float64(r.SimStep)*s.DT
+	}
+	if r.SavePer != nil {
+		s.SavePer = *r.SavePer
+	}
+	method := project.IntegrationMethod
+	if r.IntegrationMethod != "" {
+		method = r.IntegrationMethod
+	}
+	var err error
+	if s.Method, err = simulation.ParseMethod(method); err != nil {
+		return s, invalidField("integration_method", "method", err)
+	}
+	switch {
+	case r.Seed != nil:
+		s.Seed = *r.Seed
+	case project.Seed != nil:
+		s.Seed = *project.Seed
+	default:
+		s.Seed = time.Now().UnixNano()
+	}
+	if err := s.Validate(); err != nil {
+		return s, invalid(err.Error())
+	}
+	if max := jobs.Default.MaxSteps; max > 0 && (s.StopTime-s.StartTime)/s.DT > float64(max) {
+		return s, invalid(fmt.Sprintf("the run has %d steps, more than the limit of %d", s.Steps(), max))
+	}
+	return s, nil
+}
+
+// Simulate runs the project's model over the requested time range and saves the run.
+// The run is stopped once it takes longer than the time limit.
 func Simulate(ctx *fiber.Ctx) error {
-	req := new(SimulateRequest)
-	if err := ctx.BodyParser(req); err != nil {
-		return ctx.JSON(fiber.Map{"success": false, "message": "Invalid Request"})
+	req, settings, elements, err := prepareSimulation(ctx)
+	if err != nil {
+		return err
+	}
+	c, cancel, err := limitedContext()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	run, results, err := simulate(c, req, settings, elements, nil, nil)
+	if err != nil {
+		return err
 	}
-	if err := database.VL.Struct(req); err != nil {
-		valErr := err.(validator.ValidationErrors)[0]
-		msg := fmt.Sprintf("Field %s failed on %s with value %s", valErr.Field(), valErr.Tag(), valErr.Value())
-		return ctx.JSON(fiber.Map{"success": false, "message": msg})
+	return ctx.JSON(fiber.Map{"success": true, "message": "Simulation completed", "data": results, "seed": settings.Seed, "run_id": run.ID})
+}
+
+// prepareSimulation parses a simulation request, resolves its settings and loads the
+// elements it runs.
+func prepareSimulation(ctx *fiber.Ctx) (*SimulateRequest, simulation.Settings, simulation.Elements, error) {
+	req := new(SimulateRequest)
+	if err := parseBody(ctx, req); err != nil {
+		return nil, simulation.Settings{}, simulation.Elements{}, err
 	}
+	settings, elements, err := req.prepare()
+	return req, settings, elements, err
+}
 
-	var stocks []models.Stock
-	if res := models.GetStocksByProjectId(&stocks, req.ProjectID); res.Error != nil {
-		return ctx.JSON(fiber.Map{"success": false, "message": res.Error.Error()})
+// prepare resolves the settings of a parsed request and loads the elements it runs, with
+// its overrides applied.
+func (r *SimulateRequest) prepare() (simulation.Settings, simulation.Elements, error) {
+	var project models.Project
+	if res := models.GetProject(&project, r.ProjectID); res.Error != nil {
+		return simulation.Settings{}, simulation.Elements{}, dbError(res.Error, "Project")
+	}
+	settings, err := r.settings(project)
+	if err != nil {
+		return settings, simulation.Elements{}, err
 	}
+	elements, err := loadElements(r.ProjectID)
+	if err != nil {
+		return settings, elements, err
+	}
+	elements, err = overrideElements(elements, r.Overrides)
+	return settings, elements, err
+}
 
-	var variables []models.Variable
-	if res := models.GetVariablesByProjectId(&variables, req.ProjectID); res.Error != nil {
-		return ctx.JSON(fiber.Map{"success": false, "message": res.Error.Error()})
+// overrideElements applies overrides to elements, reporting overrides that cannot be
+// applied as invalid.
+func overrideElements(elements simulation.Elements, overrides map[string]models.Override) (simulation.Elements, error) {
+	elements, err := simulation.ApplyOverrides(elements, overrides)
+	if err != nil {
+		return elements, invalidField("overrides", "override", err)
 	}
+	return elements, nil
+}
 
-	stockValues := map[string]int{}
-	for _, s := range stocks {
-		val, err := utils.EvaluateExpression(s.InitialValue, stockValues, nil)
-		if err != nil {
-			return ctx.JSON(fiber.Map{"success": false, "message": err.Error()})
-		}
-		stockValues[s.Name] = val
+// limitedContext is the context of runs made outside the job pool. It first waits for a
+// slot of the pool, so that these runs and the jobs together make at most Workers at once,
+// and is done once the wait and the runs take longer than the time limit. cancel gives the
+// slot back.
+func limitedContext() (context.Context, context.CancelFunc, error) {
+	c, cancel := context.WithCancel(context.Background())
+	if jobs.Default.Timeout > 0 {
+		c, cancel = context.WithTimeout(context.Background(), jobs.Default.Timeout)
+	}
+	if err := jobs.Default.Acquire(c); err != nil {
+		cancel()
+		return nil, nil, &APIError{Status: fiber.StatusServiceUnavailable, Code: CodeBusy, Message: err.Error()}
 	}
+	return c, func() {
+		cancel()
+		jobs.Default.Release()
+	}, nil
+}
 
-	variableValues := map[string]int{}
-	for _, v := range variables {
-		val, err := utils.EvaluateExpression(v.Value, stockValues, variableValues)
-		if err != nil {
-			return ctx.JSON(fiber.Map{"success": false, "message": err.Error()})
+// simulate runs elements with settings until c is done, and saves the run. Unless it is
+// nil, emit is handed each row as soon as it is computed.
+func simulate(c context.Context, req *SimulateRequest, settings simulation.Settings, elements simulation.Elements, progress func(done, total int), emit func(row map[string]float64) error) (models.SimulationRun, []map[string]float64, error) {
+	started := time.Now()
+	var results []map[string]float64
+	err := simulation.Stream(c, elements, settings, progress, func(row map[string]float64) error {
+		results = append(results, row)
+		if emit != nil {
+			return emit(row)
 		}
-		variableValues[v.Name] = val
+		return nil
+	})
+	if err != nil {
+		return models.SimulationRun{}, nil, simulationError(err)
 	}
-
-	stockIDs := make([]uint, 0, len(stocks))
-	for _, s := range stocks {
-		stockIDs = append(stockIDs, uint(s.ID))
+	run := newRun(req, settings, elements, started)
+	run.SetResults(results)
+	if res := models.CreateRun(&run); res.Error != nil {
+		return run, nil, dbError(res.Error, "Run")
 	}
+	return run, results, nil
+}
 
-	var flows []models.Flow
-	if res := models.GetFlowsByStocks(&flows, stockIDs); res.Error != nil {
-		return ctx.JSON(fiber.Map{"success": false, "message": res.Error.Error()})
+// newRun records the run requested by req, of elements with settings, that started at
+// started and has just finished.
+func newRun(req *SimulateRequest, settings simulation.Settings, elements simulation.Elements, started time.Time) models.SimulationRun {
+	finished := time.Now()
+	return models.SimulationRun{
+		ProjectID:         req.ProjectID,
+		Label:             req.Label,
+		StartTime:         settings.StartTime,
+		StopTime:          settings.StopTime,
+		DT:                settings.DT,
+		SavePer:           settings.SavePer,
+		IntegrationMethod: string(settings.Method),
+		Seed:              settings.Seed,
+		Snapshot:          models.Snapshot(elements),
+		Overrides:         req.Overrides,
+		ScenarioID:        req.scenarioID,
+		Steps:             settings.Steps(),
+		StartedAt:         started,
+		FinishedAt:        finished,
+		DurationMS:        float64(finished.Sub(started).Microseconds()) / 1000,
 	}
+}
 
-	results := []fiber.Map{}
-	for step := 0; step < req.SimStep; step++ {
-		stepVars := map[string]int{}
-		for _, v := range variables {
-			val, err := utils.EvaluateExpression(v.Value, stockValues, variableValues)
-			if err != nil {
-				return ctx.JSON(fiber.Map{"success": false, "message": err.Error()})
-			}
-			stepVars[v.Name] = val
-		}
-		// apply flows
-		for _, f := range flows {
-			val, err := utils.EvaluateExpression(f.Name, stockValues, stepVars)
-			if err != nil {
-				return ctx.JSON(fiber.Map{"success": false, "message": err.Error()})
-			}
-			if f.FromStock != nil {
-				for _, s := range stocks {
-					if uint(s.ID) == *f.FromStock {
-						stockValues[s.Name] -= val
-					}
-				}
-			}
-			if f.ToStock != nil {
-				for _, s := range stocks {
-					if uint(s.ID) == *f.ToStock {
-						stockValues[s.Name] += val
-					}
-				}
-			}
-		}
-		snap := fiber.Map{}
-		for k, v := range stockValues {
-			snap[k] = v
-		}
-		for k, v := range stepVars {
-			snap[k] = v
-			variableValues[k] = v
-		}
-		results = append(results, snap)
+// loadElements loads the stocks, variables, flows, lookups and dimensions of a project.
+func loadElements(projectID any) (simulation.Elements, error) {
+	var elements simulation.Elements
+	if res := models.GetStocksByProjectId(&elements.Stocks, projectID); res.Error != nil {
+		return elements, res.Error
+	}
+	if res := models.GetVariablesByProjectId(&elements.Variables, projectID); res.Error != nil {
+		return elements, res.Error
+	}
+	if res := models.GetFlowsByProjectId(&elements.Flows, projectID); res.Error != nil {
+		return elements, res.Error
+	}
+	if res := models.GetLookupsByProjectId(&elements.Lookups, projectID); res.Error != nil {
+		return elements, res.Error
 	}
+	if res := models.GetDimensionsByProjectId(&elements.Dimensions, projectID); res.Error != nil {
+		return elements, res.Error
+	}
+	return elements, nil
+}
 
-	return ctx.JSON(fiber.Map{"success": true, "message": "Simulation completed", "data": results})
+// CheckModel reports the units inconsistencies of a project's model. The time unit can be
+// given with ?time_unit=; otherwise it is the project's, or inferred from the flows.
+func CheckModel(ctx *fiber.Ctx) error {
+	var project models.Project
+	if res := models.GetProject(&project, ctx.Params("id")); res.Error != nil {
+		return dbError(res.Error, "Project")
+	}
+	elements, err := loadElements(project.ID)
+	if err != nil {
+		return err
+	}
+	report := simulation.Check(elements, ctx.Query("time_unit", project.TimeUnit))
+	message := "Model is consistent"
+	if len(report.Issues) > 0 {
+		message = fmt.Sprintf("Model has %d units issues", len(report.Issues))
+	}
+	return ctx.JSON(fiber.Map{"success": true, "message": message, "data": report})
 }
@@ -1,10 +1,8 @@
 package controllers
 
 import (
-	"SystemDynamicsBackend/database"
 	"SystemDynamicsBackend/models"
-	"fmt"
-	"github.com/go-playground/validator/v10"
+	"SystemDynamicsBackend/utils"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -13,131 +11,91 @@ type CreateStockRequest struct {
 }
 
 func CreateStock(ctx *fiber.Ctx) error {
-	success := true
-	message := "New Stock Successfully Created"
 	stock := new(CreateStockRequest)
-	err := ctx.BodyParser(stock)
-	if err != nil {
-		success = false
-		message = "Invalid Request Format"
-		return ctx.JSON(fiber.Map{
-			"success": success,
-			"message": message,
-		})
+	if err := parseBody(ctx, stock); err != nil {
+		return err
 	}
 
-	newStock := models.Stock{
-		ProjectID: stock.ProjectId,
+	if err := checkProject(stock.ProjectId); err != nil {
+		return err
 	}
 
-	err = database.VL.Struct(stock)
-	if err != nil {
-		success = false
-		valErr := err.(validator.ValidationErrors)[0]
-		message = fmt.Sprintf("'%s', Failed on '%s', with value '%s'", valErr.Field(), valErr.Tag(), valErr.Value())
-		return ctx.JSON(fiber.Map{
-			"success": success,
-			"message": message,
-		})
+	newStock := models.Stock{
+		ProjectID: stock.ProjectId,
 	}
 
 	res := models.CreateStock(&newStock)
 	if res.Error != nil {
-		success = false
-		message = res.Error.Error()
-		return ctx.JSON(fiber.Map{
-			"success": success,
-			"message": message,
-		})
+		return dbError(res.Error, "Stock")
 	}
 
 	return ctx.JSON(fiber.Map{
-		"success": success,
-		"message": message,
+		"success": true,
+		"message": "New Stock Successfully Created",
 		"data":    newStock,
 	})
 }
 
 type UpdateStockRequest struct {
-	Name         string `json:"name" validate:"required"`
-	InitialValue string `json:"initial_value" validate:"required"`
+	Name         string   `json:"name" validate:"required"`
+	InitialValue string   `json:"initial_value" validate:"required"`
+	Units        string   `json:"units"`
+	Dimensions   []string `json:"dimensions" gorm:"serializer:json"`
 }
 
 func UpdateStock(ctx *fiber.Ctx) error {
-	success := true
-	message := "Stock Successfully Updated"
-
 	req := new(UpdateStockRequest)
-
 	id := ctx.Params("id")
-	err := ctx.BodyParser(req)
-
-	if err != nil {
-		success = false
-		message = "Invalid Format"
-		return ctx.JSON(fiber.Map{
-			"success": success,
-			"message": message,
-		})
+	if err := parseBody(ctx, req); err != nil {
+		return err
+	}
+	if _, err := utils.ParseUnit(req.Units); err != nil {
+		return invalidField("units", "unit", err)
 	}
 
 	res := models.UpdateStock(req, id)
-
-	if res.Error != nil || res.RowsAffected == 0 {
-		success = false
-		message = "Failed to update Stock"
+	if res.Error != nil {
+		return dbError(res.Error, "Stock")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Stock")
 	}
 
 	return ctx.JSON(fiber.Map{
-		"success": success,
-		"message": message,
+		"success": true,
+		"message": "Stock Successfully Updated",
 	})
 }
 
 func GetStocks(ctx *fiber.Ctx) error {
-	success := true
-	message := "Data Successfully Fetched"
 	var stocks []models.Stock
 
 	projectID := ctx.Query("project_id") // string olarak alır
 	if projectID != "" {
-		res := models.GetStocksByProjectId(&stocks, projectID)
-		if res.Error != nil {
-			success = false
-			message = "Failed to Get data from database"
-			return ctx.JSON(fiber.Map{
-				"success": success,
-				"message": res.Error.Error(),
-			})
+		if res := models.GetStocksByProjectId(&stocks, projectID); res.Error != nil {
+			return res.Error
 		}
-
 	} else {
-		res := models.GetStocks(&stocks)
-		if res.Error != nil {
-			success = false
-			message = "Failed to Get data from database"
-			return ctx.JSON(fiber.Map{
-				"success": success,
-				"message": message,
-			})
+		if res := models.GetStocks(&stocks); res.Error != nil {
+			return res.Error
 		}
 	}
 
 	return ctx.JSON(fiber.Map{
-		"success": success,
-		"message": message,
+		"success": true,
+		"message": "Data Successfully Fetched",
 		"data":    stocks,
 	})
 }
 
 func DeleteStock(ctx *fiber.Ctx) error {
-	success := true
-	message := "Stock Successfully Deleted"
 	id := ctx.Params("id")
 	res := models.DeleteStock(id)
-	if res.Error != nil || res.RowsAffected == 0 {
-		success = false
-		message = "Failed to Delete Stock"
+	if res.Error != nil {
+		return dbError(res.Error, "Stock")
+	}
+	if res.RowsAffected == 0 {
+		return notFound("Stock")
 	}
-	return ctx.JSON(fiber.Map{"success": success, "message": message})
+	return ctx.JSON(fiber.Map{"success": true, "message": "Stock Successfully Deleted"})
 }
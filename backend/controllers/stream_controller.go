@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+// flushInterval is the longest a computed row waits to be sent when rows are batched.
+const flushInterval = 250 * time.Millisecond
+
+// SimulateStream runs a simulation like Simulate, but sends it as Server-Sent Events while
+// it is computed: "rows" events with the rows computed since the last one and the progress
+// of the run as a percentage, then a "summary" event once the run is saved, or an "error"
+// event if it fails. ?batch=N sends rows N at a time, or as many as were computed in
+// flushInterval. A request that cannot be run is answered with an error response instead.
+func SimulateStream(ctx *fiber.Ctx) error {
+	batch := ctx.QueryInt("batch", 1)
+	if batch < 1 {
+		return invalidField("batch", "gt", fmt.Errorf("batch must be greater than 0"))
+	}
+	req, settings, elements, err := prepareSimulation(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		c, cancel, err := limitedContext()
+		if err != nil {
+			sendEvent(w, "error", errorBody(apiError(err)))
+			return
+		}
+		defer cancel()
+
+		var rows []map[string]float64
+		last := time.Now()
+		send := func() error {
+			t := rows[len(rows)-1]["time"]
+			progress := 100 * (t - settings.StartTime) / (settings.StopTime - settings.StartTime)
+			err := sendEvent(w, "rows", fiber.Map{"rows": rows, "progress": progress})
+			rows, last = nil, time.Now()
+			return err
+		}
+		run, _, err := simulate(c, req, settings, elements, nil, func(row map[string]float64) error {
+			rows = append(rows, row)
+			if len(rows) >= batch || time.Since(last) >= flushInterval {
+				// Fails once the client has gone, which stops the run.
+				return send()
+			}
+			return nil
+		})
+		if err == nil && len(rows) > 0 {
+			err = send()
+		}
+		if err != nil {
+			sendEvent(w, "error", errorBody(apiError(err)))
+			return
+		}
+		sendEvent(w, "summary", fiber.Map{
+			"run_id":      run.ID,
+			"seed":        settings.Seed,
+			"rows":        run.Rows,
+			"steps":       run.Steps,
+			"duration_ms": run.DurationMS,
+		})
+	})
+	return nil
+}
+
+// sendEvent writes a Server-Sent Event with data encoded as JSON and flushes it.
+func sendEvent(w *bufio.Writer, event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return w.Flush()
+}
@@ -5,13 +5,27 @@ import (
 	"github.com/go-playground/validator/v10"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"reflect"
+	"strings"
 )
 
 var DB *gorm.DB
 var VL = validator.New()
 
+func init() {
+	// Validation errors name fields as clients send them.
+	VL.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
 func Connect() {
-	db, err := gorm.Open(sqlite.Open("sql.db"))
+	// SQLite only enforces foreign keys when asked to, per connection.
+	db, err := gorm.Open(sqlite.Open("sql.db?_foreign_keys=on"), &gorm.Config{TranslateError: true})
 
 	if err != nil {
 		fmt.Println("DB connection error")
@@ -0,0 +1,284 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Status is the stage a job is in. A job is queued until a worker picks it up, then running
+// until it succeeds, fails or is canceled.
+type Status string
+
+const (
+	Queued    Status = "queued"
+	Running   Status = "running"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+	Canceled  Status = "canceled"
+)
+
+// ErrQueueFull is returned by Submit when every worker is busy and the queue is full.
+var ErrQueueFull = errors.New("too many simulations are waiting to run, try again later")
+
+// ErrBusy is returned by Acquire when no worker became free in time.
+var ErrBusy = errors.New("too many simulations are running, try again later")
+
+// Func is the work of a job. It should stop with ctx.Err() once ctx is done, and report its
+// progress as the number of steps done out of the total.
+type Func func(ctx context.Context, progress func(done, total int)) (any, error)
+
+// Config sizes the pool and limits the simulations it runs. Workers, Timeout and MaxSteps
+// also apply to simulations run outside the pool. For the durations and MaxSteps 0 means no
+// limit.
+type Config struct {
+	Workers   int
+	QueueSize int
+	MaxSteps  int
+	Timeout   time.Duration
+	// Retention is how long finished jobs are kept for their state to be fetched.
+	Retention time.Duration
+}
+
+// ConfigFromEnv reads the configuration from SIM_WORKERS, SIM_QUEUE_SIZE, SIM_MAX_STEPS,
+// SIM_TIMEOUT and SIM_JOB_RETENTION, the last two as durations such as "90s". Unset or
+// invalid variables keep their default.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Workers:   runtime.NumCPU(),
+		QueueSize: 100,
+		MaxSteps:  1000000,
+		Timeout:   time.Minute,
+		Retention: time.Hour,
+	}
+	for name, n := range map[string]*int{"SIM_WORKERS": &cfg.Workers, "SIM_QUEUE_SIZE": &cfg.QueueSize, "SIM_MAX_STEPS": &cfg.MaxSteps} {
+		if v := os.Getenv(name); v != "" {
+			if i, err := strconv.Atoi(v); err == nil && i >= 0 {
+				*n = i
+			} else {
+				fmt.Println("Invalid " + name)
+			}
+		}
+	}
+	for name, d := range map[string]*time.Duration{"SIM_TIMEOUT": &cfg.Timeout, "SIM_JOB_RETENTION": &cfg.Retention} {
+		if v := os.Getenv(name); v != "" {
+			if t, err := time.ParseDuration(v); err == nil && t >= 0 {
+				*d = t
+			} else {
+				fmt.Println("Invalid " + name)
+			}
+		}
+	}
+	return cfg
+}
+
+// Pool runs jobs on a fixed number of workers, so that at most Workers simulations run at
+// once and at most QueueSize wait. Simulations run outside the pool take a worker's slot
+// with Acquire, so that they count against the same limit.
+type Pool struct {
+	Config
+	queue chan *Job
+	slots chan struct{}
+	mu    sync.Mutex
+	jobs  map[string]*Job
+}
+
+// Default is the pool of the server, set up by Start.
+var Default *Pool
+
+// Start sets up Default and its workers.
+func Start(cfg Config) {
+	Default = NewPool(cfg)
+}
+
+// NewPool returns a pool with its workers started.
+func NewPool(cfg Config) *Pool {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	p := &Pool{Config: cfg, queue: make(chan *Job, cfg.QueueSize), slots: make(chan struct{}, cfg.Workers), jobs: map[string]*Job{}}
+	for i := 0; i < cfg.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit queues fn to run as a new job.
+func (p *Pool) Submit(fn Func) (*Job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{ID: newID(), fn: fn, ctx: ctx, cancel: cancel, status: Queued, created: time.Now()}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forgetFinished()
+	select {
+	case p.queue <- job:
+	default:
+		cancel()
+		return nil, ErrQueueFull
+	}
+	p.jobs[job.ID] = job
+	return job, nil
+}
+
+// Get returns the job with the given ID, or nil if there is none or it has been forgotten.
+func (p *Pool) Get(id string) *Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jobs[id]
+}
+
+// forgetFinished drops the jobs that finished more than Retention ago. p.mu must be held.
+func (p *Pool) forgetFinished() {
+	if p.Retention == 0 {
+		return
+	}
+	for id, job := range p.jobs {
+		if s := job.State(); !s.FinishedAt.IsZero() && time.Since(s.FinishedAt) > p.Retention {
+			delete(p.jobs, id)
+		}
+	}
+}
+
+// Acquire takes a slot for a simulation run outside the pool, waiting until one is free. It
+// fails with ErrBusy if ctx is done first. Release gives the slot back.
+func (p *Pool) Acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ErrBusy
+	}
+}
+
+// Release gives back a slot taken with Acquire.
+func (p *Pool) Release() {
+	<-p.slots
+}
+
+func (p *Pool) work() {
+	for job := range p.queue {
+		// Jobs stay queued while runs outside the pool hold the slots.
+		p.slots <- struct{}{}
+		p.run(job)
+		<-p.slots
+	}
+}
+
+func (p *Pool) run(job *Job) {
+	job.mu.Lock()
+	if job.status != Queued {
+		// Canceled while it waited.
+		job.mu.Unlock()
+		return
+	}
+	job.status, job.started = Running, time.Now()
+	job.mu.Unlock()
+
+	ctx, cancel := job.ctx, job.cancel
+	if p.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(job.ctx, p.Timeout)
+	}
+	defer cancel()
+	result, err := job.fn(ctx, job.setProgress)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.finished = time.Now()
+	switch {
+	case err == nil:
+		job.status, job.result = Succeeded, result
+	case errors.Is(err, context.Canceled) && job.ctx.Err() != nil:
+		job.status = Canceled
+	default:
+		job.status, job.err = Failed, err
+	}
+}
+
+// Job is a run submitted to a Pool.
+type Job struct {
+	ID     string
+	fn     Func
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu                         sync.Mutex
+	status                     Status
+	done, total                int
+	result                     any
+	err                        error
+	created, started, finished time.Time
+}
+
+// State is what is known of a job at one moment.
+type State struct {
+	Status     Status
+	Done       int
+	Total      int
+	Result     any
+	Err        error
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Progress is the percentage of the steps that are done.
+func (s State) Progress() float64 {
+	switch {
+	case s.Status == Succeeded:
+		return 100
+	case s.Total == 0:
+		return 0
+	}
+	return 100 * float64(s.Done) / float64(s.Total)
+}
+
+func (j *Job) State() State {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return State{
+		Status:     j.status,
+		Done:       j.done,
+		Total:      j.total,
+		Result:     j.result,
+		Err:        j.err,
+		CreatedAt:  j.created,
+		StartedAt:  j.started,
+		FinishedAt: j.finished,
+	}
+}
+
+// Cancel stops the job. A queued job is canceled at once, a running one when its Func
+// next checks its context. It returns false if the job has already finished.
+func (j *Job) Cancel() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.status {
+	case Queued:
+		j.status, j.finished = Canceled, time.Now()
+	case Running:
+	default:
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+func (j *Job) setProgress(done, total int) {
+	j.mu.Lock()
+	j.done, j.total = done, total
+	j.mu.Unlock()
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,29 +1,57 @@
 package main
 
 import (
+	"SystemDynamicsBackend/controllers"
 	"SystemDynamicsBackend/database"
+	"SystemDynamicsBackend/jobs"
 	"SystemDynamicsBackend/models"
 	"SystemDynamicsBackend/routes"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func main() {
 
 	database.Connect()
 
-	err := database.DB.AutoMigrate(
-		&models.Stock{},
-		&models.Project{},
-		&models.Variable{},
-		&models.Flow{},
-	)
+	if err := models.MigrateFlowEquations(); err != nil {
+		fmt.Println("Flow equation migration error")
+	}
+
+	// Rows saved before the foreign keys were enforced may reference deleted projects, and
+	// adding a constraint copies the table, so migrate on one connection without enforcing them.
+	err := database.DB.Connection(func(tx *gorm.DB) error {
+		tx.Exec("PRAGMA foreign_keys = OFF")
+		defer tx.Exec("PRAGMA foreign_keys = ON")
+		return tx.AutoMigrate(
+			&models.Stock{},
+			&models.Project{},
+			&models.Variable{},
+			&models.Flow{},
+			&models.Lookup{},
+			&models.Dimension{},
+			&models.Scenario{},
+			&models.SimulationRun{},
+			&models.RunResult{},
+		)
+	})
 
 	if err != nil {
 		fmt.Println("Migration error")
 	}
 
-	app := fiber.New()
+	if err := models.MigrateFlowProjects(); err != nil {
+		fmt.Println("Flow project migration error")
+	}
+
+	if err := models.MigrateFlowNames(); err != nil {
+		fmt.Println("Flow name migration error")
+	}
+
+	jobs.Start(jobs.ConfigFromEnv())
+
+	app := fiber.New(fiber.Config{ErrorHandler: controllers.ErrorHandler})
 
 	routes.SetupRoutes(app)
 
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"SystemDynamicsBackend/database"
+	"gorm.io/gorm"
+)
+
+// Dimension is a named list of subscripts, e.g. Region: North, South, East. Stocks, variables
+// and flows subscripted by it have one instance per element.
+type Dimension struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Elements  []string `json:"elements" gorm:"serializer:json"`
+	ProjectID uint     `json:"project_id"`
+}
+
+func CreateDimension(dimension *Dimension) *gorm.DB {
+	return database.DB.Create(dimension)
+}
+
+func GetDimensions(dimensions *[]Dimension) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Find(dimensions)
+}
+
+func GetDimension(dimension *Dimension, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("id = ?", id).First(dimension)
+}
+
+func GetDimensionsByProjectId(dimensions *[]Dimension, projectID any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("project_id = ?", projectID).Find(dimensions)
+}
+
+// UpdateDimension saves the name and elements of dimension.
+func UpdateDimension(dimension *Dimension, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Model(&Dimension{}).Where("id = ?", id).Select("Name", "Elements").Updates(dimension)
+}
+
+func DeleteDimension(id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Delete(&Dimension{}, id)
+}
@@ -5,12 +5,60 @@ import (
 	"gorm.io/gorm"
 )
 
-// Flow represents movement between stocks. FromStock and ToStock can be nil.
+// Flow represents movement between stocks of its project. FromStock and ToStock can be nil,
+// and are set to nil when their stock is deleted.
+// Equation is the rate of the flow per time unit; other expressions reference the flow by Name.
 type Flow struct {
-	ID        int    `json:"id"`
-	Name      string `json:"name" gorm:"default:'New Flow'"`
-	FromStock *uint  `json:"from_stock"`
-	ToStock   *uint  `json:"to_stock"`
+	ID          int      `json:"id"`
+	Name        string   `json:"name" gorm:"default:'New Flow'"`
+	Equation    string   `json:"equation" gorm:"default:0"`
+	Units       string   `json:"units"`
+	Description string   `json:"description"`
+	FromStock   *uint    `json:"from_stock"`
+	ToStock     *uint    `json:"to_stock"`
+	Dimensions  []string `json:"dimensions" gorm:"serializer:json"`
+	ProjectID   uint     `json:"project_id" gorm:"index"`
+
+	Source *Stock `json:"-" gorm:"foreignKey:FromStock;constraint:OnDelete:SET NULL"`
+	Target *Stock `json:"-" gorm:"foreignKey:ToStock;constraint:OnDelete:SET NULL"`
+}
+
+// MigrateFlowEquations adds the equation column to a flows table created before Flow had one.
+// Those flows stored their formula in Name, so it is copied over and the flow is named
+// "Flow <id>" instead. It must run before AutoMigrate, which would otherwise add the column
+// with its default value.
+func MigrateFlowEquations() error {
+	migrator := database.DB.Migrator()
+	if !migrator.HasTable(&Flow{}) || migrator.HasColumn(&Flow{}, "Equation") {
+		return nil
+	}
+	if err := migrator.AddColumn(&Flow{}, "Equation"); err != nil {
+		return err
+	}
+	return database.DB.Model(&Flow{}).Where("1 = 1").Updates(map[string]any{
+		"equation": gorm.Expr("name"),
+		"name":     gorm.Expr("'Flow ' || id"),
+	}).Error
+}
+
+// MigrateFlowNames renames every flow that has the name of an earlier flow of its project to
+// "Flow <id>", since equations could not tell them apart. Flows created without a name used
+// to all be named "New Flow".
+func MigrateFlowNames() error {
+	return database.DB.Exec(`UPDATE flows SET name = 'Flow ' || id WHERE EXISTS (
+		SELECT 1 FROM flows AS earlier
+		WHERE earlier.project_id = flows.project_id AND earlier.name = flows.name AND earlier.id < flows.id
+	)`).Error
+}
+
+// MigrateFlowProjects sets the project of flows created before Flow had one to the project
+// of the stock they drain or, failing that, fill. It must run after AutoMigrate has added
+// the column.
+func MigrateFlowProjects() error {
+	return database.DB.Exec(`UPDATE flows SET project_id = (
+		SELECT stocks.project_id FROM stocks JOIN projects ON projects.id = stocks.project_id
+		WHERE stocks.id = COALESCE(flows.from_stock, flows.to_stock)
+	) WHERE project_id IS NULL`).Error
 }
 
 func CreateFlow(flow *Flow) *gorm.DB {
@@ -18,22 +66,21 @@ func CreateFlow(flow *Flow) *gorm.DB {
 }
 
 func GetFlows(flows *[]Flow) *gorm.DB {
-	return database.DB.Find(flows)
+	return database.DB.Scopes(inLiveProject).Find(flows)
+}
+
+func GetFlowsByProjectId(flows *[]Flow, projectID any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("project_id = ?", projectID).Find(flows)
 }
 
 func GetFlow(flow *Flow, id any) *gorm.DB {
-	return database.DB.Where("id = ?", id).First(flow)
+	return database.DB.Scopes(inLiveProject).Where("id = ?", id).First(flow)
 }
 
 func UpdateFlow(data any, id any) *gorm.DB {
-	return database.DB.Model(&Flow{}).Where("id = ?", id).Updates(data)
+	return database.DB.Scopes(inLiveProject).Model(&Flow{}).Where("id = ?", id).Updates(data)
 }
 
 func DeleteFlow(id any) *gorm.DB {
-	return database.DB.Delete(&Flow{}, id)
-}
-
-// GetFlowsByStocks fetches flows that are connected to any of the given stock IDs.
-func GetFlowsByStocks(flows *[]Flow, ids []uint) *gorm.DB {
-	return database.DB.Where("from_stock IN ? OR to_stock IN ?", ids, ids).Find(flows)
+	return database.DB.Scopes(inLiveProject).Delete(&Flow{}, id)
 }
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"SystemDynamicsBackend/database"
+	"gorm.io/gorm"
+)
+
+// LookupPoint is one (x, y) point of a lookup table.
+type LookupPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Lookup is a graphical function: a nonlinear relationship given as points ordered by X.
+// Interpolation is "linear" or "step". Outside the points the curve is clamped to the
+// first/last Y unless Extrapolate is set, in which case the end segments are continued.
+type Lookup struct {
+	ID            int           `json:"id"`
+	Name          string        `json:"name"`
+	Points        []LookupPoint `json:"points" gorm:"serializer:json"`
+	Interpolation string        `json:"interpolation" gorm:"default:linear"`
+	Extrapolate   bool          `json:"extrapolate"`
+	ProjectID     uint          `json:"project_id"`
+}
+
+func CreateLookup(lookup *Lookup) *gorm.DB {
+	return database.DB.Create(lookup)
+}
+
+func GetLookups(lookups *[]Lookup) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Find(lookups)
+}
+
+func GetLookup(lookup *Lookup, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("id = ?", id).First(lookup)
+}
+
+func GetLookupsByProjectId(lookups *[]Lookup, projectID any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("project_id = ?", projectID).Find(lookups)
+}
+
+// UpdateLookup saves every column of lookup, so that points can be replaced and
+// Extrapolate can be switched off.
+func UpdateLookup(lookup *Lookup, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Model(&Lookup{}).Where("id = ?", id).
+		Select("Name", "Points", "Interpolation", "Extrapolate").Updates(lookup)
+}
+
+func DeleteLookup(id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Delete(&Lookup{}, id)
+}
@@ -5,9 +5,38 @@ import (
 	"gorm.io/gorm"
 )
 
+// Project groups the elements of a model with the settings it is meant to run with.
+// A simulation request may override any of the settings. SavePer is the interval between
+// saved results, 0 for every DT; without a Seed each run picks its own.
+//
+// A soft-deleted project has DeletedAt set. It is left out of every query, but keeps its
+// elements until it is restored or deleted for good. The element slices are never loaded;
+// they declare the foreign keys from the elements to their project.
 type Project struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID                int            `json:"id"`
+	Name              string         `json:"name"`
+	StartTime         float64        `json:"start_time" gorm:"default:0"`
+	StopTime          float64        `json:"stop_time" gorm:"default:100"`
+	DT                float64        `json:"dt" gorm:"column:dt;default:1"`
+	TimeUnit          string         `json:"time_unit"`
+	IntegrationMethod string         `json:"integration_method" gorm:"default:'euler'"`
+	SavePer           float64        `json:"save_per" gorm:"default:0"`
+	Seed              *int64         `json:"seed"`
+	DeletedAt         gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+
+	Stocks     []Stock         `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Variables  []Variable      `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Flows      []Flow          `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Lookups    []Lookup        `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Dimensions []Dimension     `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Scenarios  []Scenario      `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Runs       []SimulationRun `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// inLiveProject limits a query of elements, runs or scenarios to those of projects that are
+// not soft-deleted, so that they disappear from every route together with their project.
+func inLiveProject(db *gorm.DB) *gorm.DB {
+	return db.Where("project_id IN (?)", database.DB.Model(&Project{}).Select("id"))
 }
 
 func CreateProject(project *Project) *gorm.DB {
@@ -20,3 +49,55 @@ func GetProjects(projects *[]Project) *gorm.DB {
 func GetProject(project *Project, id any) *gorm.DB {
 	return database.DB.Where("id = ?", id).First(&project)
 }
+
+// GetDeletedProjects finds the soft-deleted projects.
+func GetDeletedProjects(projects *[]Project) *gorm.DB {
+	return database.DB.Unscoped().Where("deleted_at IS NOT NULL").Find(&projects)
+}
+
+// UpdateProject saves the name and every setting of project, including zero values.
+func UpdateProject(project *Project, id any) *gorm.DB {
+	return database.DB.Model(&Project{}).Where("id = ?", id).
+		Select("name", "start_time", "stop_time", "dt", "time_unit", "integration_method", "save_per", "seed").
+		Updates(project)
+}
+
+// SoftDeleteProject marks a project deleted, keeping its elements.
+func SoftDeleteProject(id any) *gorm.DB {
+	return database.DB.Delete(&Project{}, id)
+}
+
+// RestoreProject brings back a soft-deleted project.
+func RestoreProject(id any) *gorm.DB {
+	return database.DB.Unscoped().Model(&Project{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+}
+
+// DeleteProject deletes a project, soft-deleted or not, together with its flows, including
+// flows of other projects connected to its stocks, its stocks, variables, lookups and
+// dimensions, and its scenarios and runs. Either all of them are deleted or, on error, none. It returns
+// gorm.ErrRecordNotFound if there is no project id.
+func DeleteProject(id any) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		stocks := tx.Model(&Stock{}).Select("id").Where("project_id = ?", id)
+		if res := tx.Where("project_id = ? OR from_stock IN (?) OR to_stock IN (?)", id, stocks, stocks).Delete(&Flow{}); res.Error != nil {
+			return res.Error
+		}
+		runs := tx.Model(&SimulationRun{}).Select("id").Where("project_id = ?", id)
+		if res := tx.Where("run_id IN (?)", runs).Delete(&RunResult{}); res.Error != nil {
+			return res.Error
+		}
+		for _, model := range []any{&Stock{}, &Variable{}, &Lookup{}, &Dimension{}, &SimulationRun{}, &Scenario{}} {
+			if res := tx.Where("project_id = ?", id).Delete(model); res.Error != nil {
+				return res.Error
+			}
+		}
+		res := tx.Unscoped().Delete(&Project{}, id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
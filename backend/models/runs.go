@@ -0,0 +1,141 @@
+package models
+
+import (
+	"SystemDynamicsBackend/database"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// Snapshot is a copy of the elements of a project as they were when a run was made, so that
+// the run can be understood, or repeated, after the model has been edited.
+type Snapshot struct {
+	Stocks     []Stock     `json:"stocks"`
+	Variables  []Variable  `json:"variables"`
+	Flows      []Flow      `json:"flows"`
+	Lookups    []Lookup    `json:"lookups"`
+	Dimensions []Dimension `json:"dimensions"`
+}
+
+// SimulationRun is a completed simulation of a project: the settings it was run with, the
+// model it simulated and how long it took. The snapshot holds the elements as simulated,
+// with Overrides applied; ScenarioID is the scenario they came from, if any, and is set to
+// nil when it is deleted. Its time series are stored as RunResults, one per saved element,
+// and are only loaded by GetRunResults.
+type SimulationRun struct {
+	ID                int                 `json:"id"`
+	ProjectID         uint                `json:"project_id" gorm:"index"`
+	Label             string              `json:"label"`
+	StartTime         float64             `json:"start_time"`
+	StopTime          float64             `json:"stop_time"`
+	DT                float64             `json:"dt" gorm:"column:dt"`
+	SavePer           float64             `json:"save_per"`
+	IntegrationMethod string              `json:"integration_method"`
+	Seed              int64               `json:"seed"`
+	Snapshot          Snapshot            `json:"snapshot" gorm:"serializer:json"`
+	Overrides         map[string]Override `json:"overrides" gorm:"serializer:json"`
+	ScenarioID        *uint               `json:"scenario_id" gorm:"index"`
+	Steps             int                 `json:"steps"`
+	Rows              int                 `json:"rows"`
+	StartedAt         time.Time           `json:"started_at"`
+	FinishedAt        time.Time           `json:"finished_at"`
+	DurationMS        float64             `json:"duration_ms" gorm:"column:duration_ms"`
+
+	Scenario *Scenario   `json:"-" gorm:"constraint:OnDelete:SET NULL"`
+	Results  []RunResult `json:"-" gorm:"foreignKey:RunID;constraint:OnDelete:CASCADE"`
+}
+
+// RunResult is the time series of one element of a run, "time" included: its value at each
+// saved row, in order.
+type RunResult struct {
+	ID     int       `json:"id"`
+	RunID  uint      `json:"run_id" gorm:"index"`
+	Name   string    `json:"name"`
+	Values []float64 `json:"values" gorm:"serializer:json"`
+}
+
+// SetResults stores the rows returned by a simulation as the time series of run.
+func (run *SimulationRun) SetResults(rows []map[string]float64) {
+	run.Rows = len(rows)
+	run.Results = nil
+	if len(rows) == 0 {
+		return
+	}
+	names := make([]string, 0, len(rows[0]))
+	for name := range rows[0] {
+		if name != "time" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range append([]string{"time"}, names...) {
+		values := make([]float64, len(rows))
+		for i, row := range rows {
+			values[i] = row[name]
+		}
+		run.Results = append(run.Results, RunResult{Name: name, Values: values})
+	}
+}
+
+// ResultRows turns the time series of a run back into rows, as returned by a simulation.
+func ResultRows(results []RunResult) []map[string]float64 {
+	var n int
+	if len(results) > 0 {
+		n = len(results[0].Values)
+	}
+	rows := make([]map[string]float64, n)
+	for i := range rows {
+		rows[i] = make(map[string]float64, len(results))
+		for _, r := range results {
+			if i < len(r.Values) {
+				rows[i][r.Name] = r.Values[i]
+			}
+		}
+	}
+	return rows
+}
+
+// CreateRun saves a run together with its time series.
+func CreateRun(run *SimulationRun) *gorm.DB {
+	return database.DB.Create(run)
+}
+
+func GetRuns(runs *[]SimulationRun) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Order("id DESC").Find(runs)
+}
+
+func GetRunsByProjectId(runs *[]SimulationRun, projectID any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("project_id = ?", projectID).Order("id DESC").Find(runs)
+}
+
+func GetRun(run *SimulationRun, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("id = ?", id).First(run)
+}
+
+// GetRunResults loads the time series of a run, in the order they were saved.
+func GetRunResults(results *[]RunResult, runID any) *gorm.DB {
+	return database.DB.Where("run_id = ?", runID).Order("id").Find(results)
+}
+
+// LabelRun sets the label of a run; an empty label removes it.
+func LabelRun(label string, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Model(&SimulationRun{}).Where("id = ?", id).Select("label").Updates(SimulationRun{Label: label})
+}
+
+// DeleteRun deletes a run and its time series. It returns gorm.ErrRecordNotFound if there
+// is no run id.
+func DeleteRun(id any) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Where("run_id = ?", id).Delete(&RunResult{}); res.Error != nil {
+			return res.Error
+		}
+		res := tx.Scopes(inLiveProject).Delete(&SimulationRun{}, id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
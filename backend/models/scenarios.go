@@ -0,0 +1,53 @@
+package models
+
+import (
+	"SystemDynamicsBackend/database"
+	"gorm.io/gorm"
+)
+
+// Override changes one element for a run without editing it. Exactly one of its fields is
+// set: Value replaces the equation of a variable or flow, or the initial value of a stock,
+// with a constant; Equation replaces the equation of a variable or flow; InitialValue
+// replaces the initial value expression of a stock.
+type Override struct {
+	Value        *float64 `json:"value,omitempty"`
+	Equation     string   `json:"equation,omitempty"`
+	InitialValue string   `json:"initial_value,omitempty"`
+}
+
+// Scenario is a named set of overrides of a project's elements, keyed by element name, that
+// can be run and compared with the project as it is.
+type Scenario struct {
+	ID          int                 `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Overrides   map[string]Override `json:"overrides" gorm:"serializer:json"`
+	ProjectID   uint                `json:"project_id" gorm:"index"`
+}
+
+func CreateScenario(scenario *Scenario) *gorm.DB {
+	return database.DB.Create(scenario)
+}
+
+func GetScenarios(scenarios *[]Scenario) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Find(scenarios)
+}
+
+func GetScenario(scenario *Scenario, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("id = ?", id).First(scenario)
+}
+
+func GetScenariosByProjectId(scenarios *[]Scenario, projectID any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("project_id = ?", projectID).Find(scenarios)
+}
+
+// UpdateScenario saves the name, description and overrides of scenario, so that an empty
+// description or set of overrides replaces the current one.
+func UpdateScenario(scenario *Scenario, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Model(&Scenario{}).Where("id = ?", id).
+		Select("Name", "Description", "Overrides").Updates(scenario)
+}
+
+func DeleteScenario(id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Delete(&Scenario{}, id)
+}
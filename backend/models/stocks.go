@@ -6,10 +6,12 @@ import (
 )
 
 type Stock struct {
-	ID           int    `json:"id"`
-	Name         string `json:"name" gorm:"default:'New Stock'"`
-	InitialValue string `json:"initial_value" gorm:"default:0"`
-	ProjectID    uint   `json:"project_id"`
+	ID           int      `json:"id"`
+	Name         string   `json:"name" gorm:"default:'New Stock'"`
+	InitialValue string   `json:"initial_value" gorm:"default:0"`
+	Units        string   `json:"units"`
+	Dimensions   []string `json:"dimensions" gorm:"serializer:json"`
+	ProjectID    uint     `json:"project_id"`
 }
 
 func CreateStock(stock *Stock) *gorm.DB {
@@ -17,17 +19,21 @@ func CreateStock(stock *Stock) *gorm.DB {
 }
 
 func GetStocks(stocks *[]Stock) *gorm.DB {
-	return database.DB.Find(&stocks)
+	return database.DB.Scopes(inLiveProject).Find(&stocks)
+}
+
+func GetStock(stock *Stock, id any) *gorm.DB {
+	return database.DB.Scopes(inLiveProject).Where("id = ?", id).First(stock)
 }
 
 func GetStocksByProjectId(stocks *[]Stock, project_id any) *gorm.DB {
-	return database.DB.Where("project_id = ?", project_id).Find(&stocks)
+	return database.DB.Scopes(inLiveProject).Where("project_id = ?", project_id).Find(&stocks)
 }
 
 func UpdateStock(data any, id any) *gorm.DB {
-	return database.DB.Model(&Stock{}).Where("id=?", id).Updates(data)
+	return database.DB.Scopes(inLiveProject).Model(&Stock{}).Where("id=?", id).Updates(data)
 }
 
 func DeleteStock(id any) *gorm.DB {
-	return database.DB.Delete(&Stock{}, id)
+	return database.DB.Scopes(inLiveProject).Delete(&Stock{}, id)
 }
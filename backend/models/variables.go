@@ -6,10 +6,12 @@ import (
 )
 
 type Variable struct {
-	ID        int    `json:"id"`
-	Name      string `json:"name"`
-	Value     string `json:"value"`
-	ProjectID uint   `json:"project_id"`
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Value      string   `json:"value"`
+	Units      string   `json:"units"`
+	Dimensions []string `json:"dimensions" gorm:"serializer:json"`
+	ProjectID  uint     `json:"project_id"`
 }
 
 func CreateVariable(variable *Variable) *gorm.DB {
@@ -17,21 +19,21 @@ func CreateVariable(variable *Variable) *gorm.DB {
 }
 
 func GetVariables(vars *[]Variable) *gorm.DB {
-	return database.DB.Find(&vars)
+	return database.DB.Scopes(inLiveProject).Find(&vars)
 }
 
 func GetVariableByID(variable *Variable, id any) *gorm.DB {
-	return database.DB.Where("id = ?", id).First(&variable)
+	return database.DB.Scopes(inLiveProject).Where("id = ?", id).First(&variable)
 }
 
 func GetVariablesByProjectId(vars *[]Variable, projectID any) *gorm.DB {
-	return database.DB.Where("project_id = ?", projectID).Find(&vars)
+	return database.DB.Scopes(inLiveProject).Where("project_id = ?", projectID).Find(&vars)
 }
 
 func UpdateVariable(data any, id any) *gorm.DB {
-	return database.DB.Model(&Variable{}).Where("id = ?", id).Updates(data)
+	return database.DB.Scopes(inLiveProject).Model(&Variable{}).Where("id = ?", id).Updates(data)
 }
 
 func DeleteVariable(id any) *gorm.DB {
-	return database.DB.Delete(&Variable{}, id)
+	return database.DB.Scopes(inLiveProject).Delete(&Variable{}, id)
 }
@@ -9,6 +9,10 @@ func SetupRoutes(app *fiber.App) {
 	app.Post("/projects", controllers.CreateProject)
 	app.Get("/projects", controllers.GetProjects)
 	app.Get("/projects/:id", controllers.GetProject)
+	app.Put("/projects/:id", controllers.UpdateProject)
+	app.Delete("/projects/:id", controllers.DeleteProject)
+	app.Post("/projects/:id/restore", controllers.RestoreProject)
+	app.Get("/projects/:id/check", controllers.CheckModel)
 	app.Post("/stocks", controllers.CreateStock)
 	app.Put("/stocks/:id", controllers.UpdateStock)
 	app.Delete("/stocks/:id", controllers.DeleteStock)
@@ -26,6 +30,34 @@ func SetupRoutes(app *fiber.App) {
 	app.Get("/flows/:id", controllers.GetFlow)
 	app.Delete("/flows/:id", controllers.DeleteFlow)
 
+	app.Post("/lookups", controllers.CreateLookup)
+	app.Put("/lookups/:id", controllers.UpdateLookup)
+	app.Get("/lookups", controllers.GetLookups)
+	app.Get("/lookups/:id", controllers.GetLookup)
+	app.Delete("/lookups/:id", controllers.DeleteLookup)
+
+	app.Post("/dimensions", controllers.CreateDimension)
+	app.Put("/dimensions/:id", controllers.UpdateDimension)
+	app.Get("/dimensions", controllers.GetDimensions)
+	app.Get("/dimensions/:id", controllers.GetDimension)
+	app.Delete("/dimensions/:id", controllers.DeleteDimension)
+
 	app.Post("/simulate", controllers.Simulate)
+	app.Post("/simulate/stream", controllers.SimulateStream)
+	app.Post("/simulations", controllers.SubmitSimulation)
+	app.Get("/simulations/:id", controllers.GetSimulation)
+	app.Delete("/simulations/:id", controllers.CancelSimulation)
+	app.Post("/scenarios", controllers.CreateScenario)
+	app.Post("/scenarios/compare", controllers.CompareScenarios)
+	app.Put("/scenarios/:id", controllers.UpdateScenario)
+	app.Get("/scenarios", controllers.GetScenarios)
+	app.Get("/scenarios/:id", controllers.GetScenario)
+	app.Delete("/scenarios/:id", controllers.DeleteScenario)
+	app.Post("/scenarios/:id/run", controllers.RunScenario)
+
+	app.Get("/runs", controllers.GetRuns)
+	app.Get("/runs/:id", controllers.GetRun)
+	app.Put("/runs/:id", controllers.UpdateRun)
+	app.Delete("/runs/:id", controllers.DeleteRun)
 
 }
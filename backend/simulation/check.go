@@ -0,0 +1,153 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/utils"
+	"fmt"
+	"sort"
+)
+
+// Issue is a problem found by Check in an element of the model. Position, when set, is
+// where in the element's equation the problem is; for an equation that lists a value per
+// subscript, it is counted from the start of that value.
+type Issue struct {
+	Kind     string     `json:"kind"`
+	Element  string     `json:"element"`
+	Position *utils.Pos `json:"position,omitempty"`
+	Message  string     `json:"message"`
+}
+
+// Report is the result of checking a model.
+type Report struct {
+	// TimeUnit is the unit of time the model was checked against, "" if it is not known.
+	TimeUnit string  `json:"time_unit"`
+	Issues   []Issue `json:"issues"`
+}
+
+// Check reports the units inconsistencies in a model: units that cannot be parsed,
+// equations that add or compare quantities in different units or are not in the units
+// declared for their element, and flows that are not in the units of the stocks they
+// connect per unit of time. Elements without units are not checked.
+//
+// When timeUnit is "" the time unit is taken from the first flow, in ID order, that
+// connects a stock and has units.
+func Check(elements Elements, timeUnit string) *Report {
+	r := &Report{Issues: []Issue{}}
+	units := map[string]utils.Unit{}
+	declare := func(kind, name, s string) {
+		if s == "" {
+			return
+		}
+		u, err := utils.ParseUnit(s)
+		if err != nil {
+			r.Issues = append(r.Issues, Issue{Kind: kind, Element: name, Message: err.Error()})
+			return
+		}
+		units[name] = u
+	}
+	stockNames := map[uint]string{}
+	for _, s := range elements.Stocks {
+		declare(kindStock, s.Name, s.Units)
+		stockNames[uint(s.ID)] = s.Name
+	}
+	for _, v := range elements.Variables {
+		declare(kindVariable, v.Name, v.Units)
+	}
+	flows := append(elements.Flows[:0:0], elements.Flows...)
+	sort.Slice(flows, func(i, j int) bool { return flows[i].ID < flows[j].ID })
+	for _, f := range flows {
+		declare(kindFlow, f.Name, f.Units)
+	}
+
+	// stockOf returns the units of the stock with the given ID, if it has any.
+	stockOf := func(id *uint) (string, utils.Unit, bool) {
+		if id == nil {
+			return "", nil, false
+		}
+		name := stockNames[*id]
+		u, ok := units[name]
+		return name, u, ok
+	}
+	var time utils.Unit
+	if timeUnit != "" {
+		u, err := utils.ParseUnit(timeUnit)
+		if err != nil {
+			r.Issues = append(r.Issues, Issue{Kind: "model", Message: "time unit: " + err.Error()})
+		} else {
+			time = u
+		}
+	} else {
+		for _, f := range flows {
+			flow, ok := units[f.Name]
+			if !ok {
+				continue
+			}
+			if _, stock, ok := stockOf(f.FromStock); ok {
+				time = stock.Div(flow)
+				break
+			}
+			if _, stock, ok := stockOf(f.ToStock); ok {
+				time = stock.Div(flow)
+				break
+			}
+		}
+	}
+	if time != nil {
+		r.TimeUnit = time.String()
+	}
+
+	env := &utils.UnitEnv{
+		Units: func(name string) (utils.Unit, bool) {
+			u, ok := units[name]
+			return u, ok
+		},
+		Time: time,
+	}
+	check := func(kind, name, expr string) {
+		for _, part := range utils.SplitEquations(expr) {
+			e, err := utils.Parse(part)
+			if err != nil {
+				// The simulator reports syntax errors; there is nothing to infer units from.
+				continue
+			}
+			got, ok, issues := env.InferUnits(e)
+			for _, issue := range issues {
+				r.Issues = append(r.Issues, Issue{Kind: kind, Element: name, Position: &issue.Pos, Message: issue.Message})
+			}
+			want, declared := units[name]
+			if ok && declared && !got.Equal(want) {
+				r.Issues = append(r.Issues, Issue{
+					Kind:    kind,
+					Element: name,
+					Message: fmt.Sprintf("equation is in %s but %s %s is in %s", got, kind, name, want),
+				})
+			}
+		}
+	}
+	for _, s := range elements.Stocks {
+		check(kindStock, s.Name, s.InitialValue)
+	}
+	for _, v := range elements.Variables {
+		check(kindVariable, v.Name, v.Value)
+	}
+	for _, f := range flows {
+		check(kindFlow, f.Name, f.Equation)
+		flow, ok := units[f.Name]
+		if !ok || time == nil {
+			continue
+		}
+		for _, id := range []*uint{f.FromStock, f.ToStock} {
+			stockName, stock, ok := stockOf(id)
+			if !ok {
+				continue
+			}
+			if want := stock.Div(time); !flow.Equal(want) {
+				r.Issues = append(r.Issues, Issue{
+					Kind:    kindFlow,
+					Element: f.Name,
+					Message: fmt.Sprintf("flow is in %s but stock %s is in %s, so its flows must be in %s", flow, stockName, stock, want),
+				})
+			}
+		}
+	}
+	return r
+}
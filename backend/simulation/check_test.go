@@ -0,0 +1,101 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/models"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestCheckFlowUnits checks that a flow must be in the units of the stocks it connects per
+// unit of time.
+func TestCheckFlowUnits(t *testing.T) {
+	elements := func(flowUnits string) Elements {
+		return Elements{
+			Stocks: []models.Stock{
+				{ID: 1, Name: "Population", InitialValue: "100", Units: "people"},
+				{ID: 2, Name: "Emigrants", InitialValue: "0", Units: "people"},
+			},
+			Variables: []models.Variable{{Name: "Rate", Value: "0.1", Units: "1/year"}},
+			Flows: []models.Flow{
+				{ID: 1, Name: "Emigration", Equation: "[Population] * [Rate]", Units: flowUnits, FromStock: stockID(1), ToStock: stockID(2)},
+			},
+		}
+	}
+
+	r := Check(elements("people/year"), "year")
+	if len(r.Issues) != 0 {
+		t.Errorf("a flow in people/year between stocks in people has issues: %+v", r.Issues)
+	}
+	if r.TimeUnit != "year" {
+		t.Errorf("time unit is %q, want year", r.TimeUnit)
+	}
+
+	// Without a time unit, it is taken from the flow.
+	if r := Check(elements("people/year"), ""); r.TimeUnit != "year" || len(r.Issues) != 0 {
+		t.Errorf("inferred time unit %q with issues %+v, want year and none", r.TimeUnit, r.Issues)
+	}
+
+	r = Check(elements("people"), "year")
+	var flowIssues int
+	for _, issue := range r.Issues {
+		if issue.Element == "Emigration" && strings.Contains(issue.Message, "must be in people/year") {
+			flowIssues++
+		}
+	}
+	if flowIssues != 2 {
+		t.Errorf("a flow in people between stocks in people has %d issues about its units, want one per stock: %+v", flowIssues, r.Issues)
+	}
+}
+
+// TestCheckEquationUnits checks the units inferred for equations: quantities in different
+// units cannot be added or compared, numbers take the units of what they are added to, and
+// units that cancel out leave a dimensionless quantity.
+func TestCheckEquationUnits(t *testing.T) {
+	tests := []struct {
+		equation, units string
+		want            []string
+	}{
+		// Mismatched units.
+		{"[Population] + [Cost]", "", []string{"cannot add people and $"}},
+		{"[Population] - [Cost]", "people", []string{"cannot subtract people and $"}},
+		{"IF_THEN_ELSE([Population] > [Cost], 1, 0)", "", []string{"cannot compare people and $"}},
+		{"[Population] * 2", "$", []string{"equation is in people but variable X is in $"}},
+		{"EXP([Population])", "", []string{"the argument of EXP should be dimensionless, not in people"}},
+
+		// Numbers are in whatever units they need to be, so constants are never wrong.
+		{"[Population] + 1", "people", nil},
+		{"[Population] > 100", "dimensionless", nil},
+		{"0.5", "1/year", nil},
+		{"2 * 3", "people", nil},
+
+		// Units that cancel.
+		{"[Cost] / [Population]", "$/people", nil},
+		{"[Cost] / [Population] * [Population]", "$", nil},
+		{"[Cost] / [Population] * [Population]", "$/people", []string{"equation is in $ but variable X is in $/people"}},
+		{"[Population] / [Population]", "dimensionless", nil},
+		{"EXP([Population] / [Population])", "", nil},
+		{"[Population] / [Population] + 0.5", "", nil},
+		{"[Population] ^ 2 / [Population]", "people", nil},
+	}
+	for _, tt := range tests {
+		r := Check(Elements{
+			Stocks: []models.Stock{{ID: 1, Name: "Population", InitialValue: "100", Units: "people"}},
+			Variables: []models.Variable{
+				{Name: "Cost", Value: "5", Units: "$"},
+				{Name: "X", Value: tt.equation, Units: tt.units},
+			},
+		}, "year")
+		var got []string
+		for _, issue := range r.Issues {
+			if issue.Element != "X" {
+				t.Errorf("%s: unexpected issue in %s: %s", tt.equation, issue.Element, issue.Message)
+				continue
+			}
+			got = append(got, issue.Message)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s in %q has issues %q, want %q", tt.equation, tt.units, got, tt.want)
+		}
+	}
+}
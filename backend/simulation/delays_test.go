@@ -0,0 +1,115 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/models"
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// TestDelayBreaksLoop checks that a feedback loop through a delay or smooth is integrated
+// like one through a stock. In a = DELAY1I([b], 2, 0) with b = [a] + 1, the delay's inflow
+// b exceeds its outflow a by 1, so its level grows by 1 per time unit and a = t / 2; the
+// smooth of b over 2 moves towards b at (b - a) / 2, which gives the same a.
+func TestDelayBreaksLoop(t *testing.T) {
+	for _, call := range []string{"DELAY1I([b], 2, 0)", "SMOOTHI([b], 2, 0)"} {
+		t.Run(call, func(t *testing.T) {
+			rows := run(t, Elements{Variables: []models.Variable{
+				{Name: "a", Value: call},
+				{Name: "b", Value: "[a] + 1"},
+			}}, Settings{StopTime: 10, DT: 0.5, Method: Euler})
+			for _, row := range rows {
+				if want := row["time"] / 2; math.Abs(row["a"]-want) > 1e-9 || math.Abs(row["b"]-want-1) > 1e-9 {
+					t.Fatalf("at time %g a = %g and b = %g, want %g and %g", row["time"], row["a"], row["b"], want, want+1)
+				}
+			}
+		})
+	}
+
+	// Without an initial output, the delay starts from its input, which is a loop.
+	err := Stream(context.Background(), Elements{Variables: []models.Variable{
+		{Name: "a", Value: "DELAY1([b], 2)"},
+		{Name: "b", Value: "[a] + 1"},
+	}}, Settings{StopTime: 10, DT: 0.5}, nil, func(map[string]float64) error { return nil })
+	var cycle *CycleError
+	if !errors.As(err, &cycle) || !cycle.Initial {
+		t.Errorf("a loop through the input of DELAY1 gave %v, want an algebraic loop in the initial values", err)
+	}
+
+	// TREND reads its input, so a loop through it stays algebraic.
+	err = Stream(context.Background(), Elements{Variables: []models.Variable{
+		{Name: "a", Value: "TREND([b], 2, 0)"},
+		{Name: "b", Value: "[a] + 1"},
+	}}, Settings{StopTime: 10, DT: 0.5}, nil, func(map[string]float64) error { return nil })
+	if !errors.As(err, &cycle) || cycle.Initial {
+		t.Errorf("a loop through TREND gave %v, want an algebraic loop", err)
+	}
+}
+
+// simulate runs a model made of the given variables, which may reference each other, and
+// returns every row.
+func simulate(t *testing.T, settings Settings, variables ...models.Variable) []map[string]float64 {
+	t.Helper()
+	return run(t, Elements{Variables: variables}, settings)
+}
+
+func TestDelaySteadyState(t *testing.T) {
+	for _, call := range []string{"DELAY1(5, 4)", "DELAY3(5, 4)", "DELAYN(5, 4, 6)", "SMOOTH(5, 4)", "SMOOTH3(5, 4)"} {
+		t.Run(call, func(t *testing.T) {
+			rows := simulate(t, Settings{StopTime: 20, DT: 0.25, Method: Euler},
+				models.Variable{Name: "Output", Value: call})
+			for _, row := range rows {
+				if math.Abs(row["Output"]-5) > 1e-9 {
+					t.Fatalf("at time %g the output of a constant input is %g, want 5", row["time"], row["Output"])
+				}
+			}
+		})
+	}
+}
+
+// TestDelayStepResponse compares the response to a step of 10 at time 5 with the analytic
+// response of the delay, x time constants after the step.
+func TestDelayStepResponse(t *testing.T) {
+	firstOrder := func(x float64) float64 { return 10 * (1 - math.Exp(-x)) }
+	thirdOrder := func(x float64) float64 { return 10 * (1 - math.Exp(-3*x)*(1+3*x+9*x*x/2)) }
+	tests := []struct {
+		call string
+		want func(x float64) float64
+	}{
+		{"DELAY1([Input], 4)", firstOrder},
+		{"SMOOTH([Input], 4)", firstOrder},
+		{"DELAY3([Input], 4)", thirdOrder},
+		{"SMOOTH3([Input], 4)", thirdOrder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.call, func(t *testing.T) {
+			rows := simulate(t, Settings{StopTime: 30, DT: 0.0625, Method: RK4},
+				models.Variable{Name: "Input", Value: "STEP(10, 5)"},
+				models.Variable{Name: "Output", Value: tt.call})
+			for _, row := range rows {
+				want := 0.0
+				if row["time"] >= 5 {
+					want = tt.want((row["time"] - 5) / 4)
+				}
+				if math.Abs(row["Output"]-want) > 0.1 {
+					t.Fatalf("at time %g the output is %g, want %g", row["time"], row["Output"], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDelayFixedShift(t *testing.T) {
+	rows := simulate(t, Settings{StopTime: 10, DT: 0.5, Method: RK4},
+		models.Variable{Name: "Output", Value: "DELAY_FIXED(TIME, 3, -1)"})
+	for _, row := range rows {
+		want := -1.0
+		if row["time"] >= 3 {
+			want = row["time"] - 3
+		}
+		if row["Output"] != want {
+			t.Errorf("at time %g DELAY_FIXED(TIME, 3, -1) is %g, want %g", row["time"], row["Output"], want)
+		}
+	}
+}
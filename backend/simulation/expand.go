@@ -0,0 +1,145 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/models"
+	"SystemDynamicsBackend/utils"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// flowLink is one instance of a flow: the slot holding its rate and the stocks, by index in
+// the state vector, that it drains and fills. -1 stands for a source or sink.
+type flowLink struct {
+	slot int
+	from int
+	to   int
+}
+
+// expanded is a project with every subscripted element replaced by its instances.
+type expanded struct {
+	// elems holds the stock instances first, so that the index of a stock instance in elems
+	// is also its index in the state vector, then variables, flows and lookups.
+	elems     []element
+	numStocks int
+	flows     []flowLink // in flow ID order, then subscript order
+	scope     *utils.Scope
+}
+
+// expand lists the instances of every element. An element subscripted by dimensions has an
+// instance per combination of their elements, named by utils.SubscriptName; its equation
+// either applies to every instance or is a comma separated list with one per instance.
+//
+// A flow connected to a subscripted stock must have the same dimensions, and each of its
+// instances moves the corresponding stock instance. Flow instances connected to a stock that
+// is not subscripted all move that stock.
+func expand(elements Elements) (*expanded, error) {
+	x := &expanded{scope: &utils.Scope{
+		Slots:      map[string]int{},
+		Lookups:    map[string]*utils.LookupTable{},
+		Dimensions: map[string][]string{},
+		Subscripts: map[string][]string{},
+	}}
+	for _, d := range elements.Dimensions {
+		x.scope.Dimensions[d.Name] = d.Elements
+	}
+
+	stockInstances := map[uint][]int{}
+	stockDims := map[uint][]string{}
+	stockNames := map[uint]string{}
+	for _, s := range elements.Stocks {
+		first := len(x.elems)
+		if err := x.add(kindStock, s.Name, s.InitialValue, s.Dimensions); err != nil {
+			return nil, err
+		}
+		for i := first; i < len(x.elems); i++ {
+			stockInstances[uint(s.ID)] = append(stockInstances[uint(s.ID)], i)
+		}
+		stockDims[uint(s.ID)] = s.Dimensions
+		stockNames[uint(s.ID)] = s.Name
+	}
+	x.numStocks = len(x.elems)
+	for _, v := range elements.Variables {
+		if err := x.add(kindVariable, v.Name, v.Value, v.Dimensions); err != nil {
+			return nil, err
+		}
+	}
+
+	flows := make([]models.Flow, len(elements.Flows))
+	copy(flows, elements.Flows)
+	sort.Slice(flows, func(i, j int) bool { return flows[i].ID < flows[j].ID })
+	for _, f := range flows {
+		first := len(x.elems)
+		if err := x.add(kindFlow, f.Name, f.Equation, f.Dimensions); err != nil {
+			return nil, err
+		}
+		// connect returns the index of the stock instance that instance j of f moves.
+		connect := func(id *uint, j int) (int, error) {
+			if id == nil {
+				return -1, nil
+			}
+			instances, ok := stockInstances[*id]
+			if !ok {
+				return -1, nil
+			}
+			if len(stockDims[*id]) == 0 {
+				return instances[0], nil
+			}
+			if !slices.Equal(stockDims[*id], f.Dimensions) {
+				return 0, fmt.Errorf("flow %s must be subscripted like stock %s, by %v", f.Name, stockNames[*id], stockDims[*id])
+			}
+			return instances[j], nil
+		}
+		for j := range len(x.elems) - first {
+			from, err := connect(f.FromStock, j)
+			if err != nil {
+				return nil, err
+			}
+			to, err := connect(f.ToStock, j)
+			if err != nil {
+				return nil, err
+			}
+			x.flows = append(x.flows, flowLink{slot: first + j, from: from, to: to})
+		}
+	}
+
+	for i, e := range x.elems {
+		x.scope.Slots[e.name] = i
+	}
+	// Lookups have no equation; they are in the graph so their names cannot clash with another element.
+	for _, l := range elements.Lookups {
+		x.elems = append(x.elems, element{kind: kindLookup, name: l.Name})
+		x.scope.Lookups[l.Name] = lookupTable(l)
+	}
+	return x, nil
+}
+
+// add appends the instances of an element.
+func (x *expanded) add(kind, name, expr string, dims []string) error {
+	if len(dims) == 0 {
+		x.elems = append(x.elems, element{kind: kind, name: name, expr: expr})
+		return nil
+	}
+	lists := make([][]string, len(dims))
+	for i, d := range dims {
+		elems, ok := x.scope.Dimensions[d]
+		if !ok {
+			return fmt.Errorf("%s %s is subscripted by unknown dimension %s", kind, name, d)
+		}
+		lists[i] = elems
+	}
+	combos := utils.Combinations(lists)
+	exprs := utils.SplitEquations(expr)
+	if len(exprs) != 1 && len(exprs) != len(combos) {
+		return fmt.Errorf("%s %s has %d subscripts but its equation lists %d values", kind, name, len(combos), len(exprs))
+	}
+	x.scope.Subscripts[name] = dims
+	for i, combo := range combos {
+		e := exprs[0]
+		if len(exprs) > 1 {
+			e = exprs[i]
+		}
+		x.elems = append(x.elems, element{kind: kind, name: utils.SubscriptName(name, combo), expr: e})
+	}
+	return nil
+}
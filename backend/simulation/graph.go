@@ -0,0 +1,140 @@
+package simulation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Element kinds that take part in the dependency graph.
+const (
+	kindStock    = "stock"
+	kindVariable = "variable"
+	kindFlow     = "flow"
+	kindLookup   = "lookup"
+)
+
+// element is an equation of the model. For a stock the equation is its initial value.
+// initDeps holds the names of the elements the compiled equation reads at the initial time,
+// and deps those it reads afterwards, which leave out the inputs of delays and smooths.
+type element struct {
+	kind     string
+	name     string
+	expr     string
+	deps     []string
+	initDeps []string
+}
+
+// CycleError reports an algebraic loop: elements whose equations depend on each other
+// without a stock in between, so none of them can be evaluated first.
+//
+// Initial is set for a loop that only exists at the initial time, through the input of a
+// delay or smooth that starts from it, e.g. a = DELAY1([b], 2) and b = [a] + 1. Giving the
+// delay an initial output, as in DELAY1I([b], 2, 0), breaks it.
+type CycleError struct {
+	Members []string `json:"members"`
+	Initial bool     `json:"initial,omitempty"`
+}
+
+func (e *CycleError) Error() string {
+	msg := fmt.Sprintf("algebraic loop between %s", strings.Join(e.Members, " -> "))
+	if e.Initial {
+		msg += " in the initial values"
+	}
+	return msg
+}
+
+// evaluationOrder returns the indices of elems ordered so that every element comes after
+// the elements it depends on. With initial set this is the order of the initial values,
+// where every dependency counts. Otherwise it is the order of the later evaluations, where
+// stocks are state and the inputs of delays and smooths are read afterwards, so neither
+// orders anything. Elements with no ordering constraint between them keep their relative
+// input order.
+func evaluationOrder(elems []element, initial bool) ([]int, error) {
+	index := make(map[string]int, len(elems))
+	for i, e := range elems {
+		if j, ok := index[e.name]; ok {
+			return nil, fmt.Errorf("%s and %s are both named %q", elems[j].kind, e.kind, e.name)
+		}
+		index[e.name] = i
+	}
+
+	deps := make([][]int, len(elems))
+	dependents := make([][]int, len(elems))
+	for i, e := range elems {
+		edeps := e.deps
+		if initial {
+			edeps = e.initDeps
+		}
+		for _, dep := range edeps {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("%s %s depends on unknown element %s", e.kind, e.name, dep)
+			}
+			if !initial && elems[j].kind == kindStock {
+				continue
+			}
+			deps[i] = append(deps[i], j)
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	pending := make([]int, len(elems))
+	for i := range elems {
+		pending[i] = len(deps[i])
+	}
+	done := make([]bool, len(elems))
+	order := make([]int, 0, len(elems))
+	// Repeatedly take the first element whose dependencies are all done. Models are
+	// small, so the quadratic scan is cheaper to read than a priority queue.
+	for len(order) < len(elems) {
+		next := -1
+		for i := range elems {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, &CycleError{Members: findCycle(elems, deps, done), Initial: initial}
+		}
+		done[next] = true
+		order = append(order, next)
+		for _, d := range dependents[next] {
+			pending[d]--
+		}
+	}
+	return order, nil
+}
+
+// findCycle follows unresolved dependencies from the first unfinished element until a
+// node repeats and returns the names along that loop, closing it with the first name.
+func findCycle(elems []element, deps [][]int, done []bool) []string {
+	start := -1
+	for i := range elems {
+		if !done[i] {
+			start = i
+			break
+		}
+	}
+	position := map[int]int{}
+	var path []int
+	for cur := start; ; {
+		if p, ok := position[cur]; ok {
+			path = path[p:]
+			break
+		}
+		position[cur] = len(path)
+		path = append(path, cur)
+		for _, d := range deps[cur] {
+			if !done[d] {
+				cur = d
+				break
+			}
+		}
+	}
+	names := make([]string, 0, len(path)+1)
+	for _, i := range path {
+		names = append(names, elems[i].name)
+	}
+	return append(names, elems[path[0]].name)
+}
@@ -0,0 +1,94 @@
+package simulation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Method selects the numerical integration scheme used to advance stocks by one DT.
+type Method string
+
+const (
+	Euler Method = "euler"
+	RK2   Method = "rk2" // Heun's method
+	RK4   Method = "rk4" // classic fourth-order Runge-Kutta
+)
+
+// ParseMethod maps a request value to a Method. An empty value selects Euler.
+func ParseMethod(name string) (Method, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "euler":
+		return Euler, nil
+	case "rk2", "heun":
+		return RK2, nil
+	case "rk4":
+		return RK4, nil
+	}
+	return "", fmt.Errorf("unknown integration method %q", name)
+}
+
+// derivative writes the net rate of change of every stock for the given state at time t
+// into rates.
+type derivative func(t float64, state, rates []float64) error
+
+// integrator advances a state vector by one DT with a Method. Its buffers are allocated once
+// per run and reused at every step.
+type integrator struct {
+	method Method
+	k      [4][]float64
+	// tmp holds the intermediate states of RK2 and RK4.
+	tmp []float64
+}
+
+func newIntegrator(method Method, size int) *integrator {
+	in := &integrator{method: method, tmp: make([]float64, size)}
+	for i := range in.k {
+		in.k[i] = make([]float64, size)
+	}
+	return in
+}
+
+// step writes the state at t+dt into next, which must not share memory with state.
+func (in *integrator) step(f derivative, t, dt float64, state, next []float64) error {
+	k1, k2, k3, k4 := in.k[0], in.k[1], in.k[2], in.k[3]
+	if err := f(t, state, k1); err != nil {
+		return err
+	}
+	switch in.method {
+	case RK2:
+		axpy(in.tmp, state, dt, k1)
+		if err := f(t+dt, in.tmp, k2); err != nil {
+			return err
+		}
+		for i := range state {
+			next[i] = state[i] + dt/2*(k1[i]+k2[i])
+		}
+		return nil
+	case RK4:
+		axpy(in.tmp, state, dt/2, k1)
+		if err := f(t+dt/2, in.tmp, k2); err != nil {
+			return err
+		}
+		axpy(in.tmp, state, dt/2, k2)
+		if err := f(t+dt/2, in.tmp, k3); err != nil {
+			return err
+		}
+		axpy(in.tmp, state, dt, k3)
+		if err := f(t+dt, in.tmp, k4); err != nil {
+			return err
+		}
+		for i := range state {
+			next[i] = state[i] + dt/6*(k1[i]+2*k2[i]+2*k3[i]+k4[i])
+		}
+		return nil
+	}
+	axpy(next, state, dt, k1)
+	return nil
+}
+
+// axpy sets out to x + a*y.
+func axpy(out, x []float64, a float64, y []float64) {
+	for i := range x {
+		out[i] = x[i] + a*y[i]
+	}
+}
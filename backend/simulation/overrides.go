@@ -0,0 +1,70 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/models"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// ApplyOverrides returns a copy of elements with the given overrides, keyed by element
+// name, applied. The equation of an overridden element is replaced as a whole, so an
+// override of a subscripted element applies to all of its instances. It fails if an override
+// names no stock, variable or flow, or does not set exactly one change that suits the kind
+// of element it names.
+func ApplyOverrides(elements Elements, overrides map[string]models.Override) (Elements, error) {
+	x := Elements{
+		Stocks:     slices.Clone(elements.Stocks),
+		Variables:  slices.Clone(elements.Variables),
+		Flows:      slices.Clone(elements.Flows),
+		Lookups:    elements.Lookups,
+		Dimensions: elements.Dimensions,
+	}
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		o := overrides[name]
+		set := 0
+		for _, given := range []bool{o.Value != nil, o.Equation != "", o.InitialValue != ""} {
+			if given {
+				set++
+			}
+		}
+		if set != 1 {
+			return x, fmt.Errorf("override of %s must set exactly one of value, equation and initial_value", name)
+		}
+		value := o.Equation
+		if o.Value != nil {
+			value = strconv.FormatFloat(*o.Value, 'g', -1, 64)
+		}
+
+		if i := slices.IndexFunc(x.Stocks, func(s models.Stock) bool { return s.Name == name }); i >= 0 {
+			if o.Equation != "" {
+				return x, fmt.Errorf("stock %s has no equation; override its value or initial_value", name)
+			}
+			if o.InitialValue != "" {
+				value = o.InitialValue
+			}
+			x.Stocks[i].InitialValue = value
+			continue
+		}
+		if o.InitialValue != "" {
+			return x, fmt.Errorf("only stocks have an initial_value, and there is no stock %s", name)
+		}
+		if i := slices.IndexFunc(x.Variables, func(v models.Variable) bool { return v.Name == name }); i >= 0 {
+			x.Variables[i].Value = value
+			continue
+		}
+		if i := slices.IndexFunc(x.Flows, func(f models.Flow) bool { return f.Name == name }); i >= 0 {
+			x.Flows[i].Equation = value
+			continue
+		}
+		return x, fmt.Errorf("there is no stock, variable or flow %s to override", name)
+	}
+	return x, nil
+}
@@ -0,0 +1,57 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/models"
+	"reflect"
+	"testing"
+)
+
+var noise = []models.Variable{
+	{Name: "Uniform", Value: "RANDOM_UNIFORM(0, 10)"},
+	{Name: "Normal", Value: "RANDOM_NORMAL(-5, 5, 0, 2)"},
+	{Name: "Arrivals", Value: "POISSON(3) + RANDOM_EXPONENTIAL(2)"},
+	{Name: "Pink", Value: "PINK_NOISE(1, 0.5, 4)"},
+}
+
+func TestRandomSeed(t *testing.T) {
+	settings := Settings{StopTime: 20, DT: 0.5, Method: RK4, Seed: 42}
+	first := simulate(t, settings, noise...)
+	if again := simulate(t, settings, noise...); !reflect.DeepEqual(again, first) {
+		t.Errorf("two runs with seed 42 differ:\n%v\n%v", again[len(again)-1], first[len(first)-1])
+	}
+
+	settings.Seed = 43
+	other := simulate(t, settings, noise...)
+	for _, name := range []string{"Uniform", "Normal", "Arrivals", "Pink"} {
+		if reflect.DeepEqual(column(other, name), column(first, name)) {
+			t.Errorf("%s is the same with seeds 42 and 43", name)
+		}
+	}
+}
+
+// TestRandomStreams checks that adding random calls, in new elements or anywhere in the same
+// equation, leaves the numbers drawn by the existing calls unchanged.
+func TestRandomStreams(t *testing.T) {
+	settings := Settings{StopTime: 20, DT: 0.5, Method: Euler, Seed: 7}
+	before := simulate(t, settings, noise...)
+
+	more := append([]models.Variable{{Name: "Extra", Value: "RANDOM_UNIFORM(0, 1) * RANDOM_NORMAL(0, 1, 0.5, 0.1)"}}, noise...)
+	more[1].Value = "RANDOM_UNIFORM(0, 10) + 0 * POISSON(1)"
+	more[3].Value = "0 * RANDOM_EXPONENTIAL(5) + POISSON(3) + RANDOM_EXPONENTIAL(2)"
+	after := simulate(t, settings, more...)
+
+	for _, name := range []string{"Uniform", "Normal", "Arrivals", "Pink"} {
+		if got, want := column(after, name), column(before, name); !reflect.DeepEqual(got, want) {
+			t.Errorf("adding random calls changed %s:\ngot  %v\nwant %v", name, got, want)
+		}
+	}
+}
+
+// column returns the values of one element in every row.
+func column(rows []map[string]float64, name string) []float64 {
+	values := make([]float64, len(rows))
+	for i, row := range rows {
+		values[i] = row[name]
+	}
+	return values
+}
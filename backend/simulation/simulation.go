@@ -0,0 +1,311 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/models"
+	"SystemDynamicsBackend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Settings holds the time bounds, integration method and random seed of a run.
+// Runs with the same settings and elements produce identical results.
+//
+// SavePer is the interval between the rows of the results; 0 saves every DT.
+type Settings struct {
+	StartTime float64
+	StopTime  float64
+	DT        float64
+	SavePer   float64
+	Method    Method
+	Seed      int64
+}
+
+// Elements is the content of a project that a run simulates.
+type Elements struct {
+	Stocks     []models.Stock
+	Variables  []models.Variable
+	Flows      []models.Flow
+	Lookups    []models.Lookup
+	Dimensions []models.Dimension
+}
+
+// MaxSteps is the most steps of DT a run can have, whatever the limits of the server.
+const MaxSteps = math.MaxInt32
+
+// Steps returns the number of DT steps between StartTime and StopTime. It is only
+// meaningful for settings that pass Validate, which rejects runs of more than MaxSteps.
+func (s Settings) Steps() int {
+	return int(math.Round((s.StopTime - s.StartTime) / s.DT))
+}
+
+// saveEvery returns the number of DT steps between saved rows. A SavePer longer than the
+// run saves only its first and last rows.
+func (s Settings) saveEvery() int {
+	if s.SavePer <= 0 {
+		return 1
+	}
+	return int(math.Round(math.Min(s.SavePer, s.StopTime-s.StartTime) / s.DT))
+}
+
+// Validate checks that the settings describe a run that can be made.
+func (s Settings) Validate() error {
+	switch {
+	case !(s.DT > 0):
+		return fmt.Errorf("dt must be greater than 0")
+	case !(s.StopTime > s.StartTime):
+		return fmt.Errorf("stop_time must be greater than start_time")
+	case (s.StopTime-s.StartTime)/s.DT > MaxSteps:
+		// Compared as a float, since the step count may not fit in an int.
+		return fmt.Errorf("the run has more than %d steps of dt", MaxSteps)
+	case !isMultiple(s.StopTime-s.StartTime, s.DT):
+		return fmt.Errorf("stop_time - start_time must be a multiple of dt")
+	case s.SavePer < 0:
+		return fmt.Errorf("save_per must not be negative")
+	case s.SavePer > 0 && !isMultiple(s.SavePer, s.DT):
+		return fmt.Errorf("save_per must be a multiple of dt")
+	case s.SavePer > 0 && s.saveEvery() < 1:
+		return fmt.Errorf("save_per must not be less than dt")
+	}
+	return nil
+}
+
+// isMultiple reports whether x is a whole number of dt, up to rounding error.
+func isMultiple(x, dt float64) bool {
+	n := x / dt
+	return math.Abs(n-math.Round(n)) <= 1e-9*n
+}
+
+// model is the project being simulated. Stocks make up the integrated state vector,
+// in the order they were loaded, followed by the hidden stocks of delay and smoothing
+// functions. Variables and flows are auxiliaries: they are recomputed from the stocks
+// whenever the state is evaluated. A subscripted element takes part as its instances.
+//
+// Every equation is compiled once. Each stock, variable and flow has a slot in values, the
+// stocks first so that slot i of a stock is also its index in the state vector.
+type model struct {
+	stocks   []element
+	flows    []flowLink
+	settings Settings
+	hidden   *hiddenStocks
+	values   []float64
+	ctx      *utils.Context
+	// auxiliaries holds the variable and flow equations in dependency order.
+	auxiliaries []compiled
+}
+
+// compiled is an equation of the model with the slot its value is stored in.
+type compiled struct {
+	element
+	slot int
+	prog *utils.Program
+}
+
+// checkEvery is the number of steps between checks for cancellation and progress reports.
+const checkEvery = 100
+
+// Stream simulates the given elements from settings.StartTime to settings.StopTime and
+// hands emit one row per saved time, as soon as it is computed, holding "time" and the
+// value of every stock, variable and flow. Rows are saved every settings.SavePer and at
+// StopTime. Subscripted elements have a value per instance, keyed like Population[North].
+// The run stops with ctx.Err() once ctx is done, and an error from emit stops it and is
+// returned. Unless it is nil, progress is called every few steps with the number of steps
+// done out of the total.
+//
+// Equations are evaluated in dependency order: every equation at initialisation, and
+// variables and flows at every later evaluation, where stocks are state and do not
+// order anything. Nor do the inputs of delays and smooths, whose outputs are computed from
+// their hidden stocks and whose inputs are read once every variable and flow has its value.
+// A model whose equations reference each other in a loop is rejected with a *CycleError.
+func Stream(ctx context.Context, elements Elements, settings Settings, progress func(done, total int), emit func(row map[string]float64) error) error {
+	x, err := expand(elements)
+	if err != nil {
+		return err
+	}
+	elems := x.elems
+	programs := make([]*utils.Program, len(elems))
+	for i := range elems {
+		e := &elems[i]
+		if e.kind == kindLookup {
+			continue
+		}
+		prog, err := utils.Compile(e.name, e.expr, x.scope)
+		var undefined *utils.UndefinedReferenceError
+		if errors.As(err, &undefined) {
+			undefined.Kind = e.kind
+			return undefined
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", e.kind, e.name, err)
+		}
+		programs[i] = prog
+		e.deps, e.initDeps = prog.Dependencies(), prog.InitialDependencies()
+	}
+	// A loop that is algebraic at every step is reported as such rather than as a loop in
+	// the initial values, so the run order is computed first.
+	runOrder, err := evaluationOrder(elems, false)
+	if err != nil {
+		return err
+	}
+	initOrder, err := evaluationOrder(elems, true)
+	if err != nil {
+		return err
+	}
+
+	m := &model{
+		stocks:   elems[:x.numStocks],
+		flows:    x.flows,
+		settings: settings,
+		hidden:   newHiddenStocks(x.numStocks, settings.Seed),
+		values:   make([]float64, len(elems)),
+	}
+	m.ctx = &utils.Context{
+		Values:    m.values,
+		States:    m.hidden,
+		DT:        settings.DT,
+		StartTime: settings.StartTime,
+		StopTime:  settings.StopTime,
+	}
+	for _, i := range runOrder {
+		if elems[i].kind == kindVariable || elems[i].kind == kindFlow {
+			m.auxiliaries = append(m.auxiliaries, compiled{element: elems[i], slot: i, prog: programs[i]})
+		}
+	}
+
+	state := make([]float64, x.numStocks)
+	m.ctx.Time = settings.StartTime
+	m.ctx.Initializing = true
+	m.hidden.initializing = true
+	for _, i := range initOrder {
+		e := elems[i]
+		if e.kind == kindLookup {
+			continue
+		}
+		m.ctx.Element = e.name
+		val, err := programs[i].Eval(m.ctx)
+		if err != nil {
+			return err
+		}
+		m.values[i] = val
+		if e.kind == kindStock {
+			state[i] = val
+		}
+	}
+	m.ctx.Initializing = false
+	m.hidden.initializing = false
+	state = append(state, m.hidden.initial...)
+	// The hidden stocks are known once initialised, so the buffers of the step loop can be
+	// allocated: the new state is written into next, and the two are swapped at every step.
+	in := newIntegrator(settings.Method, len(state))
+	next := make([]float64, len(state))
+
+	steps, every := settings.Steps(), settings.saveEvery()
+	for step := 0; step <= steps; step++ {
+		if step%checkEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(step, steps)
+			}
+		}
+		// Computed from the step index rather than accumulated, so long runs do not drift.
+		t := settings.StartTime + float64(step)*settings.DT
+		if err := m.evaluateAuxiliaries(t, state, nil); err != nil {
+			return err
+		}
+		for i, s := range m.stocks {
+			// JSON has no encoding for NaN/Inf, so an overflowing stock ends the run.
+			if v := state[i]; math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("stock %s became non-finite at time %g", s.name, t)
+			}
+		}
+		if step%every == 0 || step == steps {
+			row := make(map[string]float64, len(m.stocks)+len(m.auxiliaries)+1)
+			row["time"] = t
+			for i, s := range m.stocks {
+				row[s.name] = state[i]
+			}
+			for _, a := range m.auxiliaries {
+				row[a.name] = m.values[a.slot]
+			}
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		if step == steps {
+			break
+		}
+		if err := in.step(m.derivative, t, settings.DT, state, next); err != nil {
+			return err
+		}
+		state, next = next, state
+		m.hidden.nextStep()
+	}
+	if progress != nil {
+		progress(steps, steps)
+	}
+	return nil
+}
+
+// evaluateAuxiliaries evaluates every variable and flow at time t for the given state,
+// leaving their values in m.values. Then delay and smoothing functions read their inputs and
+// write the rates of their hidden stocks into rates, unless it is nil.
+func (m *model) evaluateAuxiliaries(t float64, state, rates []float64) error {
+	m.hidden.state, m.hidden.rates = state, rates
+	copy(m.values, state[:len(m.stocks)])
+	m.ctx.Time = t
+	for _, a := range m.auxiliaries {
+		m.ctx.Element = a.name
+		val, err := a.prog.Eval(m.ctx)
+		if err != nil {
+			return err
+		}
+		m.values[a.slot] = val
+	}
+	for _, a := range m.auxiliaries {
+		m.ctx.Element = a.name
+		if err := a.prog.UpdateStates(m.ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// derivative evaluates every flow as a rate per time unit for the given state and
+// sums them into rates, the net rate of change of each stock.
+//
+// All flow rates are computed from the same state before any of them is applied, and
+// they are summed in flow ID order, so the result does not depend on the order the
+// flows were loaded in, down to floating-point rounding.
+func (m *model) derivative(t float64, state, rates []float64) error {
+	// The hidden stocks of a function in an IF_THEN_ELSE branch that is not taken do not change.
+	clear(rates)
+	if err := m.evaluateAuxiliaries(t, state, rates); err != nil {
+		return err
+	}
+	for _, f := range m.flows {
+		rate := m.values[f.slot]
+		if f.from >= 0 {
+			rates[f.from] -= rate
+		}
+		if f.to >= 0 {
+			rates[f.to] += rate
+		}
+	}
+	return nil
+}
+
+func lookupTable(l models.Lookup) *utils.LookupTable {
+	table := &utils.LookupTable{
+		X:           make([]float64, len(l.Points)),
+		Y:           make([]float64, len(l.Points)),
+		Step:        l.Interpolation == "step",
+		Extrapolate: l.Extrapolate,
+	}
+	for i, p := range l.Points {
+		table.X[i], table.Y[i] = p.X, p.Y
+	}
+	return table
+}
@@ -0,0 +1,143 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/models"
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func stockID(id uint) *uint {
+	return &id
+}
+
+// run simulates elements with settings and returns every row.
+func run(t *testing.T, elements Elements, settings Settings) []map[string]float64 {
+	t.Helper()
+	var rows []map[string]float64
+	err := Stream(context.Background(), elements, settings, nil, func(row map[string]float64) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// TestFlowOrder checks that the rates of all flows are computed from the same state, so
+// that the order the flows are loaded in does not change the results.
+func TestFlowOrder(t *testing.T) {
+	elements := Elements{
+		Stocks: []models.Stock{
+			{ID: 1, Name: "Susceptible", InitialValue: "990"},
+			{ID: 2, Name: "Infected", InitialValue: "10"},
+			{ID: 3, Name: "Recovered", InitialValue: "0"},
+		},
+		Variables: []models.Variable{
+			{Name: "Contact Rate", Value: "0.3"},
+			{Name: "Net Change", Value: "[Infection] - [Recovery] - [Deaths]"},
+		},
+		Flows: []models.Flow{
+			{ID: 1, Name: "Infection", Equation: "[Contact Rate] * [Susceptible] * [Infected] / 1000", FromStock: stockID(1), ToStock: stockID(2)},
+			{ID: 2, Name: "Recovery", Equation: "[Infected] * 0.1", FromStock: stockID(2), ToStock: stockID(3)},
+			{ID: 3, Name: "Deaths", Equation: "[Infected] * 0.01", FromStock: stockID(2)},
+			{ID: 4, Name: "Immunity Loss", Equation: "[Recovered] * 0.05", FromStock: stockID(3), ToStock: stockID(1)},
+		},
+	}
+	reordered := elements
+	reordered.Flows = slices.Clone(elements.Flows)
+	slices.Reverse(reordered.Flows)
+	reordered.Flows[0], reordered.Flows[2] = reordered.Flows[2], reordered.Flows[0]
+
+	for _, method := range []Method{Euler, RK2, RK4} {
+		t.Run(string(method), func(t *testing.T) {
+			settings := Settings{StopTime: 20, DT: 0.25, Method: method}
+			want := run(t, elements, settings)
+			got := run(t, reordered, settings)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("reordering the flows changed the results:\ngot  %v\nwant %v", got[len(got)-1], want[len(want)-1])
+			}
+		})
+	}
+}
+
+// BenchmarkRun runs a model of 400 elements, with delays, smooths and random functions, for
+// 2000 steps. Rows are saved every 100 steps, so that the allocations reported are mostly
+// those of setting the run up; the step loop does not allocate.
+func BenchmarkRun(b *testing.B) {
+	var elements Elements
+	for i := 1; i <= 100; i++ {
+		id := uint(i)
+		stock, next := fmt.Sprintf("Stock %d", i), fmt.Sprintf("Stock %d", i%100+1)
+		rate := fmt.Sprintf("Rate %d", i)
+		elements.Stocks = append(elements.Stocks, models.Stock{ID: i, Name: stock, InitialValue: "100"})
+		elements.Variables = append(elements.Variables, models.Variable{Name: rate, Value: fmt.Sprintf("0.01 + 0.001 * SIN(TIME / 10) * SMOOTH([%s], 5) / ([%s] + 1) + RANDOM_UNIFORM(0, 0.001)", next, stock)})
+		elements.Flows = append(elements.Flows,
+			models.Flow{ID: 2*i - 1, Name: fmt.Sprintf("Transfer %d", i), Equation: fmt.Sprintf("[%s] * [%s]", stock, rate), FromStock: stockID(id), ToStock: stockID(uint(i%100 + 1))},
+			models.Flow{ID: 2 * i, Name: fmt.Sprintf("Inflow %d", i), Equation: fmt.Sprintf("DELAY3(MAX(0, 50 - [%s]) * 0.05, 4)", stock), ToStock: stockID(id)},
+		)
+	}
+	settings := Settings{StopTime: 2000, DT: 1, SavePer: 100, Method: RK4}
+	discard := func(map[string]float64) error { return nil }
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := Stream(context.Background(), elements, settings, nil, discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestStepsDoNotAllocate checks that a run of ten times as many steps, with the same rows
+// saved, allocates no more, whatever the integration method and stateful functions used.
+func TestStepsDoNotAllocate(t *testing.T) {
+	elements := Elements{
+		Stocks: []models.Stock{{ID: 1, Name: "Stock", InitialValue: "10"}},
+		Variables: []models.Variable{
+			{Name: "Smoothed", Value: "SMOOTH3([Stock], 2) + DELAY1([Stock], 3) + TREND([Stock], 2, 0)"},
+			{Name: "Noise", Value: "RANDOM_NORMAL(-1, 1, 0, 0.5) + PINK_NOISE(0, 1, 2) + DELAY_FIXED([Stock], 1, 0)"},
+		},
+		Flows: []models.Flow{{ID: 1, Name: "Inflow", Equation: "0.01 * [Smoothed] + 0.001 * [Noise]", ToStock: stockID(1)}},
+	}
+	discard := func(map[string]float64) error { return nil }
+	for _, method := range []Method{Euler, RK2, RK4} {
+		allocs := func(stop float64) float64 {
+			settings := Settings{StopTime: stop, DT: 0.25, SavePer: stop, Method: method}
+			return testing.AllocsPerRun(5, func() {
+				if err := Stream(context.Background(), elements, settings, nil, discard); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+		if short, long := allocs(10), allocs(100); long > short {
+			t.Errorf("%s: a run of 400 steps allocates %g times, one of 40 steps %g", method, long, short)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		settings Settings
+		err      string
+	}{
+		{Settings{StopTime: 10, DT: 0.25}, ""},
+		{Settings{StopTime: 10, DT: 0.25, SavePer: 1e19}, ""},
+		{Settings{StopTime: 10, DT: 0}, "dt must be greater than 0"},
+		{Settings{StopTime: 10, DT: 3}, "stop_time - start_time must be a multiple of dt"},
+		{Settings{StopTime: 10, DT: 0.25, SavePer: 0.3}, "save_per must be a multiple of dt"},
+		// Step counts that do not fit in an int must not wrap around and pass.
+		{Settings{StopTime: 1e19, DT: 1}, "the run has more than 2147483647 steps of dt"},
+		{Settings{StartTime: -1e308, StopTime: 1e308, DT: 1}, "the run has more than 2147483647 steps of dt"},
+		{Settings{StopTime: MaxSteps + 1, DT: 1}, "the run has more than 2147483647 steps of dt"},
+		{Settings{StopTime: MaxSteps, DT: 1}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.settings.Validate()
+		if got := fmt.Sprint(err); tt.err == "" && err != nil || tt.err != "" && got != tt.err {
+			t.Errorf("%+v: Validate() = %v, want %q", tt.settings, err, tt.err)
+		}
+	}
+}
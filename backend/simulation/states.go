@@ -0,0 +1,137 @@
+package simulation
+
+import (
+	"SystemDynamicsBackend/utils"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+)
+
+// hiddenStocks implements utils.StateStore. The levels of delay and smoothing functions are
+// appended to the state vector after the model's stocks, so the integrator advances them
+// with the same method and DT. DELAY_FIXED pipelines and random streams are kept apart and
+// move on once per step.
+type hiddenStocks struct {
+	offset map[string]int // site -> index of its first level in the state vector
+	size   map[string]int
+	// initial holds the initial levels in allocation order; they follow the stocks in the state vector.
+	initial      []float64
+	numStocks    int
+	initializing bool
+
+	// state and rates are the vectors of the evaluation in progress. rates is nil when the
+	// evaluation reports the values of a result row rather than computing a derivative;
+	// only those evaluations record DELAY_FIXED inputs.
+	state []float64
+	rates []float64
+
+	pipelines map[string][]float64
+	recorded  map[string]float64
+
+	seed    int64
+	step    int
+	streams map[string]*randomStream
+}
+
+// randomStream is the random number generator of one call site and its latest draw.
+type randomStream struct {
+	rng   *rand.Rand
+	step  int
+	value float64
+	drawn bool
+}
+
+func newHiddenStocks(numStocks int, seed int64) *hiddenStocks {
+	return &hiddenStocks{
+		offset:    map[string]int{},
+		size:      map[string]int{},
+		numStocks: numStocks,
+		pipelines: map[string][]float64{},
+		recorded:  map[string]float64{},
+		seed:      seed,
+		streams:   map[string]*randomStream{},
+	}
+}
+
+func (h *hiddenStocks) Levels(site string, n int, init float64) ([]float64, error) {
+	offset, ok := h.offset[site]
+	if !ok {
+		if !h.initializing {
+			return nil, fmt.Errorf("%s was not initialised", site)
+		}
+		h.offset[site] = h.numStocks + len(h.initial)
+		h.size[site] = n
+		for range n {
+			h.initial = append(h.initial, init)
+		}
+		return h.initial[len(h.initial)-n:], nil
+	}
+	if h.size[site] != n {
+		return nil, fmt.Errorf("%s changed its order from %d to %d", site, h.size[site], n)
+	}
+	if h.initializing {
+		offset -= h.numStocks
+		return h.initial[offset : offset+n], nil
+	}
+	return h.state[offset : offset+n], nil
+}
+
+func (h *hiddenStocks) Rates(site string) []float64 {
+	if h.rates == nil {
+		return nil
+	}
+	offset := h.offset[site]
+	return h.rates[offset : offset+h.size[site]]
+}
+
+func (h *hiddenStocks) Delayed(site string, length int, init, input float64) (float64, error) {
+	pipeline, ok := h.pipelines[site]
+	if !ok {
+		if !h.initializing {
+			return 0, fmt.Errorf("%s was not initialised", site)
+		}
+		pipeline = make([]float64, length)
+		for i := range pipeline {
+			pipeline[i] = init
+		}
+		h.pipelines[site] = pipeline
+	}
+	if len(pipeline) != length {
+		return 0, fmt.Errorf("%s changed its delay from %d to %d steps", site, len(pipeline), length)
+	}
+	if h.rates == nil {
+		h.recorded[site] = input
+	}
+	if length == 0 {
+		return input, nil
+	}
+	return pipeline[0], nil
+}
+
+func (h *hiddenStocks) Random(site string, draw utils.Draw, ctx *utils.Context, a []float64) (float64, error) {
+	stream, ok := h.streams[site]
+	if !ok {
+		// The stream depends only on the seed and the site, not on the order sites are met.
+		hash := fnv.New64a()
+		hash.Write([]byte(site))
+		stream = &randomStream{rng: rand.New(rand.NewPCG(uint64(h.seed), hash.Sum64()))}
+		h.streams[site] = stream
+	}
+	if !stream.drawn || stream.step != h.step {
+		stream.value = draw(ctx, stream.rng, a, stream.value, !stream.drawn)
+		stream.step, stream.drawn = h.step, true
+	}
+	return stream.value, nil
+}
+
+// nextStep moves every DELAY_FIXED pipeline on by one step, appending the input recorded at
+// the step just reported, and lets random functions draw new values.
+func (h *hiddenStocks) nextStep() {
+	for site, pipeline := range h.pipelines {
+		if n := len(pipeline); n > 0 {
+			copy(pipeline, pipeline[1:])
+			pipeline[n-1] = h.recorded[site]
+		}
+	}
+	h.step++
+}
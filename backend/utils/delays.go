@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// StateStore keeps the internal state of stateful functions such as DELAY1 and SMOOTH.
+// Every call site of such a function in the model gets its own state, identified by a
+// site key built from the element being evaluated and the text of the call, so editing one
+// call of an equation does not move the state of the others. Identical calls in the same
+// equation are told apart by the order in which they appear. The simulation engine implements StateStore and integrates the hidden stocks
+// together with the model's own stocks.
+type StateStore interface {
+	// Levels returns the current values of the n hidden stocks of site. While the model is
+	// being initialised, the first call for a site allocates them, each starting at init.
+	Levels(site string, n int, init float64) ([]float64, error)
+	// Rates returns the rates of change of the hidden stocks of site for the current
+	// evaluation, to be written in place, or nil if the evaluation computes no rates.
+	Rates(site string) []float64
+	// Delayed returns the input that site recorded length steps ago, or init while fewer steps
+	// have been recorded, and records input for the current step.
+	Delayed(site string, length int, init, input float64) (float64, error)
+	// Random returns the value site drew for the current time step. At the first evaluation in
+	// a step it calls draw with ctx, a and the site's own random stream.
+	Random(site string, draw Draw, ctx *Context, a []float64) (float64, error)
+}
+
+// Draw draws the next value of a random function called with arguments a in ctx from r. prev
+// is the previous value of the site, unless first is set. A Draw reads everything it needs from
+// its arguments, rather than capturing it, so that passing it does not allocate.
+type Draw func(ctx *Context, r *rand.Rand, a []float64, prev float64, first bool) float64
+
+// stateful is a function whose output depends on its past inputs, e.g. DELAY1([Orders], 3).
+// maxArgs < 0 means the function takes any number of arguments from minArgs up.
+type stateful struct {
+	minArgs int
+	maxArgs int
+	fn      func(ctx *Context, site string, args []float64) (float64, error)
+}
+
+// statefuls are the delay and smoothing functions, keyed by upper-case name.
+var statefuls = map[string]stateful{
+	// DELAY1(input, delay) is a first-order material delay. DELAY1I takes the initial output.
+	"DELAY1": {2, 2, func(ctx *Context, site string, a []float64) (float64, error) {
+		return delayChain(ctx, site, a[0], a[1], 1, a[0])
+	}},
+	"DELAY1I": {3, 3, func(ctx *Context, site string, a []float64) (float64, error) {
+		return delayChain(ctx, site, a[0], a[1], 1, a[2])
+	}},
+	// DELAY3(input, delay) is a third-order material delay. DELAY3I takes the initial output.
+	"DELAY3": {2, 2, func(ctx *Context, site string, a []float64) (float64, error) {
+		return delayChain(ctx, site, a[0], a[1], 3, a[0])
+	}},
+	"DELAY3I": {3, 3, func(ctx *Context, site string, a []float64) (float64, error) {
+		return delayChain(ctx, site, a[0], a[1], 3, a[2])
+	}},
+	// DELAYN(input, delay, order) is a material delay of any order, DELAYN(input, delay, order, init)
+	// with an initial output.
+	"DELAYN": {3, 4, func(ctx *Context, site string, a []float64) (float64, error) {
+		order := a[2]
+		if order < 1 || order != math.Trunc(order) {
+			return 0, fmt.Errorf("DELAYN order must be a positive integer, got %g", order)
+		}
+		init := a[0]
+		if len(a) == 4 {
+			init = a[3]
+		}
+		return delayChain(ctx, site, a[0], a[1], int(order), init)
+	}},
+	// SMOOTH(input, time) is first-order exponential smoothing. SMOOTHI takes the initial output.
+	"SMOOTH": {2, 2, func(ctx *Context, site string, a []float64) (float64, error) {
+		return smoothChain(ctx, site, a[0], a[1], 1, a[0])
+	}},
+	"SMOOTHI": {3, 3, func(ctx *Context, site string, a []float64) (float64, error) {
+		return smoothChain(ctx, site, a[0], a[1], 1, a[2])
+	}},
+	// SMOOTH3(input, time) is third-order exponential smoothing. SMOOTH3I takes the initial output.
+	"SMOOTH3": {2, 2, func(ctx *Context, site string, a []float64) (float64, error) {
+		return smoothChain(ctx, site, a[0], a[1], 3, a[0])
+	}},
+	"SMOOTH3I": {3, 3, func(ctx *Context, site string, a []float64) (float64, error) {
+		return smoothChain(ctx, site, a[0], a[1], 3, a[2])
+	}},
+	// TREND(input, averaging time, initial trend) is the fractional rate of change of input,
+	// measured against its first-order smooth.
+	"TREND": {3, 3, func(ctx *Context, site string, a []float64) (float64, error) {
+		input, avgTime, initTrend := a[0], a[1], a[2]
+		if avgTime <= 0 {
+			return 0, fmt.Errorf("TREND averaging time must be positive, got %g", avgTime)
+		}
+		levels, err := ctx.States.Levels(site, 1, input/(1+initTrend*avgTime))
+		if err != nil {
+			return 0, err
+		}
+		average := levels[0]
+		if rates := ctx.States.Rates(site); rates != nil {
+			rates[0] = (input - average) / avgTime
+		}
+		if average == 0 {
+			return 0, nil
+		}
+		return (input - average) / (average * avgTime), nil
+	}},
+	// DELAY_FIXED(input, delay, init) is input exactly delay time units ago, and init before that.
+	// It is a pipeline shifted once per DT rather than a stock, so it is not integrated.
+	"DELAY_FIXED": {3, 3, func(ctx *Context, site string, a []float64) (float64, error) {
+		if a[1] < 0 {
+			return 0, fmt.Errorf("DELAY_FIXED delay must not be negative, got %g", a[1])
+		}
+		return ctx.States.Delayed(site, int(math.Round(a[1]/ctx.DT)), a[2], a[0])
+	}},
+}
+
+// buffered are the functions whose output is computed from their hidden stocks alone. Their
+// input only sets the rates of those stocks, so it is read by Program.UpdateStates rather than
+// when the call is evaluated, and a feedback loop through them is not an algebraic loop, as
+// through any stock. At the initial time the input is read only when the call has no initial
+// output, whose argument index is given here. TREND is not among them: its output reads its
+// input.
+var buffered = map[string]int{
+	"DELAY1": 2, "DELAY1I": 2, "DELAY3": 2, "DELAY3I": 2, "DELAYN": 3,
+	"SMOOTH": 2, "SMOOTHI": 2, "SMOOTH3": 2, "SMOOTH3I": 2,
+}
+
+// delayChain is an nth-order material delay: n hidden stocks in series, each draining at
+// level / (delay / n). Each stock starts at init * delay / n, so the initial output is init.
+// input is only read while the states are updated.
+func delayChain(ctx *Context, site string, input, delay float64, n int, init float64) (float64, error) {
+	if delay <= 0 {
+		return 0, fmt.Errorf("delay time must be positive, got %g", delay)
+	}
+	stage := delay / float64(n)
+	levels, err := ctx.States.Levels(site, n, init*stage)
+	if err != nil {
+		return 0, err
+	}
+	if rates := ctx.States.Rates(site); ctx.updating && rates != nil {
+		inflow := input
+		for i, level := range levels {
+			outflow := level / stage
+			rates[i] = inflow - outflow
+			inflow = outflow
+		}
+	}
+	return levels[n-1] / stage, nil
+}
+
+// smoothChain is nth-order exponential smoothing: n hidden stocks in series, each adjusting
+// towards the previous one over time / n. Each stock starts at init. input is only read while
+// the states are updated.
+func smoothChain(ctx *Context, site string, input, time float64, n int, init float64) (float64, error) {
+	if time <= 0 {
+		return 0, fmt.Errorf("smoothing time must be positive, got %g", time)
+	}
+	stage := time / float64(n)
+	levels, err := ctx.States.Levels(site, n, init)
+	if err != nil {
+		return 0, err
+	}
+	if rates := ctx.States.Rates(site); ctx.updating && rates != nil {
+		target := input
+		for i, level := range levels {
+			rates[i] = (target - level) / stage
+			target = level
+		}
+	}
+	return levels[n-1], nil
+}
+
+// lookupStateful returns the stateful or random function called name, if there is one.
+func lookupStateful(name string) (stateful, bool) {
+	if s, ok := statefuls[functionName(name)]; ok {
+		return s, true
+	}
+	s, ok := randoms[functionName(name)]
+	return s, ok
+}
+
+// site returns the site key of call: the element and the call as printed by Format, followed
+// by #2, #3, ... for the second and later calls with the same text.
+func (c *compiler) site(call *Call) string {
+	text := Format(call)
+	if c.sites == nil {
+		c.sites = make(map[string]int)
+	}
+	c.sites[text]++
+	if n := c.sites[text]; n > 1 {
+		return fmt.Sprintf("%s#%s#%d", c.element, text, n)
+	}
+	return c.element + "#" + text
+}
+
+// compileStateful compiles a call of a stateful function, with the site key given by site.
+//
+// After the initial time, the call of a buffered function leaves its input out and only notes
+// that it was reached; the input is evaluated by its update, which Program.UpdateStates runs.
+// At the initial time it leaves the input out if it has an initial output.
+func (c *compiler) compileStateful(n *Call, s stateful) (evalFunc, error) {
+	upper := functionName(n.Name)
+	if err := checkArity(upper, s.minArgs, s.maxArgs, len(n.Args)); err != nil {
+		return nil, err
+	}
+	index := len(c.statefuls)
+	c.statefuls = append(c.statefuls, nil)
+	initArg, isBuffered := buffered[upper]
+	hasInit := isBuffered && len(n.Args) > initArg
+	args := make([]evalFunc, len(n.Args))
+	for i, node := range n.Args {
+		buffering, skipInit := c.buffering, c.skipInit
+		if isBuffered && i == 0 {
+			c.buffering, c.skipInit = true, skipInit || hasInit
+		}
+		arg, err := c.compile(node)
+		c.buffering, c.skipInit = buffering, skipInit
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+	site := c.site(&Call{Name: upper, Args: n.Args})
+	values := make([]float64, len(args))
+	reached := false
+	call := func(ctx *Context) (float64, error) {
+		if ctx.States == nil {
+			return 0, fmt.Errorf("%s can only be used in a simulation", upper)
+		}
+		for i, arg := range args {
+			if i == 0 && isBuffered && !ctx.updating && (!ctx.Initializing || hasInit) {
+				reached = !ctx.Initializing
+				continue
+			}
+			var err error
+			if values[i], err = arg(ctx); err != nil {
+				return 0, err
+			}
+		}
+		return s.fn(ctx, site, values)
+	}
+	c.statefuls[index] = call
+	if isBuffered {
+		c.updates = append(c.updates, func(ctx *Context) error {
+			if !reached {
+				return nil
+			}
+			reached = false
+			_, err := call(ctx)
+			return err
+		})
+	}
+	return call, nil
+}
@@ -2,64 +2,486 @@ package utils
 
 import (
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
-	"regexp"
-	"strconv"
+	"math"
+	"slices"
+	"strings"
 )
 
-// EvaluateExpression replaces [name] tokens using provided maps and evaluates the arithmetic expression.
-func EvaluateExpression(expr string, stocks map[string]int, vars map[string]int) (int, error) {
-	r := regexp.MustCompile(`\[(.+?)\]`)
-	expr = r.ReplaceAllStringFunc(expr, func(s string) string {
-		key := s[1 : len(s)-1]
-		if val, ok := stocks[key]; ok {
-			return fmt.Sprintf("%d", val)
-		}
-		if val, ok := vars[key]; ok {
-			return fmt.Sprintf("%d", val)
-		}
-		return "0"
-	})
+// Context is everything a compiled Program reads while it is evaluated: the current value of
+// stocks and of auxiliaries (variables and flows), in Values at the slots its Scope assigned,
+// and the simulation clock. Lookup tables are bound when the program is compiled.
+type Context struct {
+	Values    []float64
+	Time      float64
+	DT        float64
+	StartTime float64
+	StopTime  float64
+
+	// States holds the hidden state of delay and smoothing functions, and Element names the
+	// element being evaluated so that each of its calls gets its own state. Initializing is
+	// set while the model's initial values are computed.
+	States       StateStore
+	Element      string
+	Initializing bool
+
+	// updating is set while Program.UpdateStates reads the inputs of delays and smooths.
+	updating bool
+}
+
+// clockNames are the identifiers that read the simulation clock, e.g. [Stock] * TIME.
+var clockNames = map[string]func(ctx *Context) float64{
+	"TIME":         func(ctx *Context) float64 { return ctx.Time },
+	"DT":           func(ctx *Context) float64 { return ctx.DT },
+	"TIME_STEP":    func(ctx *Context) float64 { return ctx.DT },
+	"INITIAL_TIME": func(ctx *Context) float64 { return ctx.StartTime },
+	"FINAL_TIME":   func(ctx *Context) float64 { return ctx.StopTime },
+}
+
+// reached reports whether the clock has arrived at time t, within half a DT.
+func (ctx *Context) reached(t float64) bool {
+	return ctx.Time+ctx.DT/2 > t
+}
+
+// inPulse reports whether the clock is inside [start, start+width). A width of 0 means one DT.
+func (ctx *Context) inPulse(start, width float64) bool {
+	if width <= 0 {
+		width = ctx.DT
+	}
+	return ctx.reached(start) && !ctx.reached(start+width)
+}
+
+// Scope tells the compiler what the [name] references of an expression stand for.
+type Scope struct {
+	// Slots maps element names to their index in Context.Values.
+	Slots   map[string]int
+	Lookups map[string]*LookupTable
+	// Dimensions maps dimension names to their elements, and Subscripts maps the name of each
+	// subscripted element to the names of its dimensions. Every instance of a subscripted
+	// element has a slot of its own, named by SubscriptName.
+	Dimensions map[string][]string
+	Subscripts map[string][]string
+}
+
+// names returns the names of the elements and lookups in scope, sorted, for suggestions. The
+// instances of a subscripted element are listed once, by the element's name.
+func (s *Scope) names() []string {
+	names := make([]string, 0, len(s.Slots)+len(s.Lookups))
+	for name := range s.Slots {
+		base, _, _ := strings.Cut(name, "[")
+		names = append(names, base)
+	}
+	for name := range s.Lookups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Program is an expression compiled for repeated evaluation. References are resolved to
+// slots and lookup tables, and function names and arities are checked, when it is compiled,
+// so evaluating it neither parses nor allocates. A Program is not safe for concurrent use.
+type Program struct {
+	source string
+	root   evalFunc
+	// statefuls are the stateful calls in source order. They are all evaluated at the
+	// initial time, including those in a branch of IF_THEN_ELSE that is not selected then.
+	statefuls []evalFunc
+	// updates set the rates of the delays and smooths reached by the last Eval.
+	updates  []func(ctx *Context) error
+	deps     []string
+	initDeps []string
+}
+
+type evalFunc func(ctx *Context) (float64, error)
+
+// Compile compiles the equation expr of element; Parse describes the syntax. Expressions may
+// call the built-in functions in functions.go, e.g. MAX([Stock] - 10, 0). Comparisons and
+// logical operators yield 1 for true and 0 for false, and any non-zero operand counts as true.
+// TIME, DT, INITIAL_TIME and FINAL_TIME read the simulation clock. A lookup table in scope is
+// called as [name](x), [name]:x or LOOKUP([name], x). Delay and smoothing functions (delays.go)
+// keep their state in the context's States, under site keys made of element and the text of
+// the call. A reference that is not in scope is reported as an *UndefinedReferenceError.
+//
+// When element is an instance of a subscripted element, e.g. Births[North], references to
+// other subscripted elements are resolved for that instance; see Scope.
+func Compile(element, expr string, scope *Scope) (*Program, error) {
+	node, err := Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{element: element, source: expr, scope: scope, binding: scope.binding(element)}
+	root, err := c.compile(node)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{source: expr, root: root, statefuls: c.statefuls, updates: c.updates, deps: c.deps, initDeps: c.initDeps}, nil
+}
 
-	node, err := parser.ParseExpr(expr)
+// Eval evaluates the program in ctx. A result of NaN or ±Inf is reported as an error instead
+// of being returned.
+func (p *Program) Eval(ctx *Context) (float64, error) {
+	if ctx.Initializing {
+		for _, call := range p.statefuls {
+			if _, err := call(ctx); err != nil {
+				return 0, err
+			}
+		}
+	}
+	val, err := p.root(ctx)
 	if err != nil {
 		return 0, err
 	}
-	return evalNode(node)
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, fmt.Errorf("expression %q evaluated to a non-finite value (%v)", p.source, val)
+	}
+	return val, nil
 }
 
-func evalNode(node ast.Expr) (int, error) {
+// UpdateStates sets the rates of the hidden stocks of the delays and smooths that the last
+// Eval reached, which needs their inputs. Eval computes their outputs from the hidden stocks
+// alone, so the inputs may depend on the program's own value: the engine calls UpdateStates
+// once every auxiliary has been evaluated.
+func (p *Program) UpdateStates(ctx *Context) error {
+	ctx.updating = true
+	for _, update := range p.updates {
+		if err := update(ctx); err != nil {
+			ctx.updating = false
+			return err
+		}
+	}
+	ctx.updating = false
+	return nil
+}
+
+// Dependencies returns the names of the slots and lookups Eval reads after the initial time,
+// in order of first appearance. The inputs of delays and smooths are only read by
+// UpdateStates, so they are left out.
+func (p *Program) Dependencies() []string {
+	return p.deps
+}
+
+// InitialDependencies returns the names of the slots and lookups Eval reads at the initial
+// time, in order of first appearance: those of Dependencies and the inputs of the delays and
+// smooths that start from their input rather than from an initial output argument.
+func (p *Program) InitialDependencies() []string {
+	return p.initDeps
+}
+
+// String returns the source of the program.
+func (p *Program) String() string {
+	return p.source
+}
+
+// compiler turns the syntax tree of one equation into closures.
+type compiler struct {
+	element   string
+	source    string
+	scope     *Scope
+	statefuls []evalFunc
+	updates   []func(ctx *Context) error
+	deps      []string
+	initDeps  []string
+	// sites counts the stateful calls compiled so far by their text; see site.
+	sites map[string]int
+	// buffering is set while the input of a delay or smooth is compiled, and skipInit when
+	// that input is not read at the initial time either.
+	buffering bool
+	skipInit  bool
+	// binding holds the subscripts of the instance being compiled by dimension, and those
+	// of the enclosing aggregates by dimension followed by !.
+	binding map[string]string
+}
+
+// depend records that the program reads name. In the input of a delay or smooth it is read
+// at the initial time only, if at all.
+func (c *compiler) depend(name string) {
+	if !c.skipInit && !slices.Contains(c.initDeps, name) {
+		c.initDeps = append(c.initDeps, name)
+	}
+	if !c.buffering && !slices.Contains(c.deps, name) {
+		c.deps = append(c.deps, name)
+	}
+}
+
+func (c *compiler) compile(node Expr) (evalFunc, error) {
 	switch n := node.(type) {
-	case *ast.BasicLit:
-		if n.Kind == token.INT {
-			return strconv.Atoi(n.Value)
+	case *Number:
+		v := n.Value
+		return func(*Context) (float64, error) { return v, nil }, nil
+	case *Unary:
+		x, err := c.compile(n.X)
+		if err != nil {
+			return nil, err
 		}
-	case *ast.BinaryExpr:
-		l, err := evalNode(n.X)
+		switch n.Op {
+		case "+":
+			return x, nil
+		case "-":
+			return func(ctx *Context) (float64, error) {
+				v, err := x(ctx)
+				return -v, err
+			}, nil
+		case "NOT":
+			return func(ctx *Context) (float64, error) {
+				v, err := x(ctx)
+				return boolValue(v == 0), err
+			}, nil
+		}
+	case *Binary:
+		if n.Op == ":" {
+			return c.compileTableCall(n.X, n.Y, "the left side of : must be a lookup, e.g. [table]:x")
+		}
+		return c.compileBinary(n)
+	case *Ref:
+		if !n.Bracketed && len(n.Subscripts) == 0 {
+			if clock, ok := clockNames[strings.ToUpper(n.Name)]; ok {
+				return func(ctx *Context) (float64, error) { return clock(ctx), nil }, nil
+			}
+		}
+		name, err := c.resolve(n)
+		if err != nil {
+			return nil, err
+		}
+		if slot, ok := c.scope.Slots[name]; ok {
+			c.depend(name)
+			return func(ctx *Context) (float64, error) { return ctx.Values[slot], nil }, nil
+		}
+		if _, ok := c.scope.Lookups[n.Name]; ok {
+			return nil, fmt.Errorf("lookup %s must be called with an input, e.g. [%s](x)", n.Name, n.Name)
+		}
+		return nil, c.undefined(n.Name, n.At)
+	case *Call:
+		return c.compileCall(n)
+	}
+	return nil, fmt.Errorf("unsupported expression")
+}
+
+// undefined reports a reference to name, which is neither an element nor a lookup in scope.
+func (c *compiler) undefined(name string, at Pos) error {
+	return &UndefinedReferenceError{
+		Name:       name,
+		Element:    c.element,
+		Expression: c.source,
+		Line:       at.Line,
+		Column:     at.Column,
+		Suggestion: Suggest(name, c.scope.names()),
+	}
+}
+
+func (c *compiler) compileBinary(n *Binary) (evalFunc, error) {
+	l, err := c.compile(n.X)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.compile(n.Y)
+	if err != nil {
+		return nil, err
+	}
+	// AND and OR short-circuit: the right operand is only evaluated when it decides the result.
+	switch n.Op {
+	case "AND":
+		return func(ctx *Context) (float64, error) {
+			if x, err := l(ctx); err != nil || x == 0 {
+				return 0, err
+			}
+			y, err := r(ctx)
+			return boolValue(y != 0), err
+		}, nil
+	case "OR":
+		return func(ctx *Context) (float64, error) {
+			if x, err := l(ctx); err != nil || x != 0 {
+				return boolValue(err == nil), err
+			}
+			y, err := r(ctx)
+			return boolValue(y != 0), err
+		}, nil
+	}
+
+	var op func(x, y float64) (float64, error)
+	switch n.Op {
+	case "+":
+		op = func(x, y float64) (float64, error) { return x + y, nil }
+	case "-":
+		op = func(x, y float64) (float64, error) { return x - y, nil }
+	case "*":
+		op = func(x, y float64) (float64, error) { return x * y, nil }
+	case "/":
+		op = func(x, y float64) (float64, error) {
+			if y == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return x / y, nil
+		}
+	case "^":
+		op = func(x, y float64) (float64, error) { return math.Pow(x, y), nil }
+	case "<":
+		op = func(x, y float64) (float64, error) { return boolValue(x < y), nil }
+	case ">":
+		op = func(x, y float64) (float64, error) { return boolValue(x > y), nil }
+	case "<=":
+		op = func(x, y float64) (float64, error) { return boolValue(x <= y), nil }
+	case ">=":
+		op = func(x, y float64) (float64, error) { return boolValue(x >= y), nil }
+	case "=":
+		op = func(x, y float64) (float64, error) { return boolValue(x == y), nil }
+	case "<>":
+		op = func(x, y float64) (float64, error) { return boolValue(x != y), nil }
+	default:
+		return nil, fmt.Errorf("unsupported operator %s", n.Op)
+	}
+	return func(ctx *Context) (float64, error) {
+		x, err := l(ctx)
 		if err != nil {
 			return 0, err
 		}
-		r, err := evalNode(n.Y)
+		y, err := r(ctx)
 		if err != nil {
 			return 0, err
 		}
-		switch n.Op {
-		case token.ADD:
-			return l + r, nil
-		case token.SUB:
-			return l - r, nil
-		case token.MUL:
-			return l * r, nil
-		case token.QUO:
-			if r == 0 {
-				return 0, fmt.Errorf("division by zero")
+		return op(x, y)
+	}, nil
+}
+
+// compileCall compiles a call. A bare name is looked up among the functions first, ignoring
+// case, spaces and underscores, so a lookup named like a function must be called in brackets.
+func (c *compiler) compileCall(n *Call) (evalFunc, error) {
+	if !n.Bracketed {
+		name := functionName(n.Name)
+		if name == ifThenElse {
+			return c.compileIfThenElse(n)
+		}
+		if name == lookupFunc {
+			return c.compileLookup(n)
+		}
+		if s, ok := lookupStateful(name); ok {
+			return c.compileStateful(n, s)
+		}
+		if combine, ok := aggregates[name]; ok && len(n.Args) == 1 {
+			return c.compileAggregate(n, combine)
+		}
+		if _, ok := builtins[name]; ok {
+			return c.compileBuiltin(n)
+		}
+	}
+	if _, ok := c.scope.Lookups[n.Name]; ok {
+		if len(n.Args) != 1 {
+			return nil, fmt.Errorf("lookup %s expects 1 argument, got %d", n.Name, len(n.Args))
+		}
+		return c.compileTableCall(&Ref{At: n.At, Name: n.Name, Bracketed: n.Bracketed}, n.Args[0], "")
+	}
+	if _, ok := c.scope.Slots[n.Name]; ok {
+		return nil, fmt.Errorf("%s is not a lookup and cannot be called", n.Name)
+	}
+	if n.Bracketed {
+		return nil, c.undefined(n.Name, n.At)
+	}
+	return nil, fmt.Errorf("unknown function %s", n.Name)
+}
+
+func (c *compiler) compileBuiltin(n *Call) (evalFunc, error) {
+	b, err := lookupBuiltin(n.Name, len(n.Args))
+	if err != nil {
+		return nil, err
+	}
+	args, err := c.compileArgs(n.Args)
+	if err != nil {
+		return nil, err
+	}
+	// The argument buffer is reused by every evaluation of this call.
+	values := make([]float64, len(args))
+	return func(ctx *Context) (float64, error) {
+		for i, arg := range args {
+			var err error
+			if values[i], err = arg(ctx); err != nil {
+				return 0, err
 			}
-			return l / r, nil
 		}
-	case *ast.ParenExpr:
-		return evalNode(n.X)
+		return b.fn(ctx, values)
+	}, nil
+}
+
+func (c *compiler) compileArgs(nodes []Expr) ([]evalFunc, error) {
+	args := make([]evalFunc, len(nodes))
+	for i, node := range nodes {
+		var err error
+		if args[i], err = c.compile(node); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// ifThenElse is the conditional function. Unlike the other built-ins its arguments are
+// not all evaluated up front: only the branch selected by the condition is.
+const ifThenElse = "IF_THEN_ELSE"
+
+// compileIfThenElse compiles IF_THEN_ELSE(cond, a, b). A non-zero cond selects a, zero selects b,
+// so IF_THEN_ELSE([x] > 0, [y] / [x], 0) never divides by zero.
+func (c *compiler) compileIfThenElse(n *Call) (evalFunc, error) {
+	if len(n.Args) != 3 {
+		return nil, fmt.Errorf("%s expects 3 argument(s), got %d", ifThenElse, len(n.Args))
+	}
+	args, err := c.compileArgs(n.Args)
+	if err != nil {
+		return nil, err
+	}
+	cond, then, otherwise := args[0], args[1], args[2]
+	return func(ctx *Context) (float64, error) {
+		v, err := cond(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if v != 0 {
+			return then(ctx)
+		}
+		return otherwise(ctx)
+	}, nil
+}
+
+// lookupFunc is the explicit form of a lookup call: LOOKUP([table], x) is [table](x).
+// Its first argument names a table rather than being evaluated.
+const lookupFunc = "LOOKUP"
+
+func (c *compiler) compileLookup(n *Call) (evalFunc, error) {
+	if len(n.Args) != 2 {
+		return nil, fmt.Errorf("%s expects 2 argument(s), got %d", lookupFunc, len(n.Args))
+	}
+	return c.compileTableCall(n.Args[0], n.Args[1], fmt.Sprintf("the first argument of %s must be a lookup, e.g. %s([table], x)", lookupFunc, lookupFunc))
+}
+
+// compileTableCall compiles the lookup named by table applied to input. When table does not
+// name a lookup in scope, the error is notLookup, or an undefined reference if it is unknown.
+func (c *compiler) compileTableCall(table, input Expr, notLookup string) (evalFunc, error) {
+	ref, ok := table.(*Ref)
+	if !ok {
+		return nil, fmt.Errorf("%s", notLookup)
+	}
+	t, ok := c.scope.Lookups[ref.Name]
+	if ok {
+		c.depend(ref.Name)
+	} else {
+		if _, isSlot := c.scope.Slots[ref.Name]; isSlot || !ref.Bracketed && clockNames[strings.ToUpper(ref.Name)] != nil {
+			return nil, fmt.Errorf("%s", notLookup)
+		}
+		return nil, c.undefined(ref.Name, ref.At)
+	}
+	x, err := c.compile(input)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx *Context) (float64, error) {
+		v, err := x(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return t.At(v), nil
+	}, nil
+}
+
+// boolValue represents a truth value as a number: 1 for true and 0 for false.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
 	}
-	return 0, fmt.Errorf("unsupported expression")
+	return 0
 }
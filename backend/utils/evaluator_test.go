@@ -0,0 +1,236 @@
+package utils_test
+
+import (
+	"SystemDynamicsBackend/utils"
+	"errors"
+	"math"
+	"testing"
+)
+
+// testScope has three elements, x = 2, y = 0 and Stock = 10, and three lookups through
+// (0, 0), (1, 10) and (2, 40): Effect clamps outside them, Trend extrapolates and Stepped
+// holds each y until the next x.
+func testScope() (*utils.Scope, *utils.Context) {
+	points := func(step, extrapolate bool) *utils.LookupTable {
+		return &utils.LookupTable{X: []float64{0, 1, 2}, Y: []float64{0, 10, 40}, Step: step, Extrapolate: extrapolate}
+	}
+	scope := &utils.Scope{
+		Slots:   map[string]int{"x": 0, "y": 1, "Stock": 2},
+		Lookups: map[string]*utils.LookupTable{"Effect": points(false, false), "Trend": points(false, true), "Stepped": points(true, false)},
+	}
+	ctx := &utils.Context{Values: []float64{2, 0, 10}, Time: 5, DT: 0.5, StartTime: 0, StopTime: 10}
+	return scope, ctx
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		// Literals and arithmetic, in float64.
+		{"1.5e3 + .5", 1500.5},
+		{"7 / 2", 3.5},
+		{"2.5E-1 * 4", 1},
+		{"10 - 4 - 3", 3},
+		{"-2^2", -4},
+		{"2^-1", 0.5},
+		{"0.1 + 0.2", 0.1 + 0.2},
+		{"[Stock] / [x] + [y]", 5},
+
+		// Comparisons and logic give 1 or 0.
+		{"3 > 2", 1},
+		{"2 = 2.0", 1},
+		{"1 <> 1", 0},
+		{"NOT [y]", 1},
+		{"[x] AND [y]", 0},
+		{"[x] OR [y]", 1},
+
+		// Built-in functions.
+		{"MIN(3, [x], 5)", 2},
+		{"MAX(3, [x], 5)", 5},
+		{"ABS(-3)", 3},
+		{"SIGN(-3)", -1},
+		{"SQRT(16)", 4},
+		{"LN(EXP(2))", 2},
+		{"LOG(1000)", 3},
+		{"LOG(8, 2)", 3},
+		{"POW(2, 10)", 1024},
+		{"ROUND(2.5)", 3},
+		{"FLOOR(-2.5)", -3},
+		{"CEIL(2.1)", 3},
+		{"INTEGER(-2.7)", -2},
+		{"MODULO(-1, 5)", 4},
+		{"MODULO(7, -5)", -3},
+		{"SAFEDIV(1, 0)", 0},
+		{"SAFEDIV(1, 0, 7)", 7},
+		{"XIDZ(4, 2, 9)", 2},
+		{"ZIDZ(1, [y])", 0},
+		{"COS(PI())", -1},
+
+		// IF_THEN_ELSE, AND and OR only evaluate what decides the result, so none of these
+		// divides by zero.
+		{"IF_THEN_ELSE([y] = 0, 0, 1 / [y])", 0},
+		{"IF_THEN_ELSE([x], [x] * 3, 1 / [y])", 6},
+		{"[y] <> 0 AND 1 / [y] > 1", 0},
+		{"[y] = 0 OR 1 / [y] > 1", 1},
+
+		// The clock, at time 5 with a DT of 0.5 in a run from 0 to 10.
+		{"TIME", 5},
+		{"time * 2", 10},
+		{"DT", 0.5},
+		{"TIME_STEP", 0.5},
+		{"INITIAL_TIME", 0},
+		{"FINAL_TIME", 10},
+		{"STEP(3, 5)", 3},
+		{"STEP(3, 5.2)", 3}, // within DT/2 of the step
+		{"STEP(3, 6)", 0},
+		{"PULSE(4, 2)", 1},
+		{"PULSE(5, 0)", 1},
+		{"PULSE(4.5, 0)", 0},
+		{"RAMP(2, 1, 3)", 4},
+		{"RAMP(2, 1)", 8},
+		{"PULSE_TRAIN(1, 1, 2, 10)", 1},
+		{"PULSE_TRAIN(0, 1, 2, 10)", 0},
+		{"PULSE_TRAIN(1, 1, 2, 4)", 0},
+
+		// Lookups interpolate linearly between their points, clamp or extrapolate outside
+		// them, or hold each value until the next point.
+		{"[Effect](0.5)", 5},
+		{"[Effect]:1.5", 25},
+		{"LOOKUP([Effect], [x])", 40},
+		{"[Effect](-1)", 0},
+		{"[Effect](3)", 40},
+		{"[Trend](-1)", -10},
+		{"[Trend](3)", 70},
+		{"[Stepped](0.99)", 0},
+		{"[Stepped](1.5)", 10},
+		{"[Stepped](5)", 40},
+	}
+	scope, ctx := testScope()
+	for _, tt := range tests {
+		prog, err := utils.Compile("z", tt.expr, scope)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.expr, err)
+			continue
+		}
+		got, err := prog.Eval(ctx)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+// TestEvalErrors checks the errors of equations that cannot be compiled, because of a wrong
+// call, and of those that cannot be evaluated, because they give no finite number.
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		compile bool // whether the error is reported by Compile rather than Eval
+		err     string
+	}{
+		{"SAFEDIV(1)", true, "SAFEDIV expects at least 2 arguments, got 1"},
+		{"MAX(1)", true, "MAX of one argument combines the elements of a dimension marked with !, e.g. MAX(Population[Region!])"},
+		{"ABS(1, 2)", true, "ABS expects 1 argument(s), got 2"},
+		{"LOG(1, 2, 3)", true, "LOG expects at most 2 arguments, got 3"},
+		{"PI(1)", true, "PI expects 0 argument(s), got 1"},
+		{"IF_THEN_ELSE(1, 2)", true, "IF_THEN_ELSE expects 3 argument(s), got 2"},
+		{"LOOKUP([Effect])", true, "LOOKUP expects 2 argument(s), got 1"},
+		{"[Effect](1, 2)", true, "lookup Effect expects 1 argument, got 2"},
+		{"FOO(1)", true, "unknown function FOO"},
+		{"[x](1)", true, "x is not a lookup and cannot be called"},
+		{"[Effect] + 1", true, "lookup Effect must be called with an input, e.g. [Effect](x)"},
+		{"[x]:1", true, "the left side of : must be a lookup, e.g. [table]:x"},
+		{"1e999", true, "syntax error at line 1, column 1: invalid number 1e999"},
+
+		{"1 / [y]", false, "division by zero"},
+		{"MODULO(1, 0)", false, "MODULO by zero"},
+		{"SQRT(-1)", false, "SQRT of negative number -1"},
+		{"LN(0)", false, "LN of non-positive number 0"},
+		{"LOG(10, 1)", false, "LOG with invalid base 1"},
+		{"PULSE_TRAIN(0, 1, 0, 10)", false, "PULSE_TRAIN interval must be positive, got 0"},
+		{"1e308 * 10", false, `expression "1e308 * 10" evaluated to a non-finite value (+Inf)`},
+		{"0 ^ -1", false, `expression "0 ^ -1" evaluated to a non-finite value (+Inf)`},
+		{"-EXP(1000)", false, `expression "-EXP(1000)" evaluated to a non-finite value (-Inf)`},
+		{"ARCSIN(2)", false, `expression "ARCSIN(2)" evaluated to a non-finite value (NaN)`},
+	}
+	scope, ctx := testScope()
+	for _, tt := range tests {
+		prog, err := utils.Compile("z", tt.expr, scope)
+		if !tt.compile && err == nil {
+			_, err = prog.Eval(ctx)
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: error %v, want %s", tt.expr, err, tt.err)
+		}
+	}
+}
+
+// TestFunctionNames checks that function names ignore case, spaces and underscores.
+func TestFunctionNames(t *testing.T) {
+	scope := &utils.Scope{Slots: map[string]int{"x": 0}}
+	ctx := &utils.Context{Values: []float64{2}, Time: 3, DT: 1}
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"IF_THEN_ELSE([x] > 1, 10, 20)", 10},
+		{"IF THEN ELSE([x] > 1, 10, 20)", 10},
+		{"if then else([x] > 5, 10, 20)", 20},
+		{"If_Then Else([x] > 1, 10, 20)", 10},
+		{"IFTHENELSE([x] > 1, 10, 20)", 10},
+		{"PULSE TRAIN(1, 1, 2, 10)", 1},
+		{"pulse_train(0, 1, 2, 10)", 0},
+		{"Max([x], 5)", 5},
+	}
+	for _, tt := range tests {
+		prog, err := utils.Compile("y", tt.expr, scope)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.expr, err)
+			continue
+		}
+		if got, err := prog.Eval(ctx); err != nil || got != tt.want {
+			t.Errorf("%s = %v (%v), want %v", tt.expr, got, err, tt.want)
+		}
+	}
+
+	if _, err := utils.Compile("y", "IF THEN([x])", scope); err == nil || err.Error() != "unknown function IF THEN" {
+		t.Errorf(`Compile("IF THEN([x])") error %v, want unknown function IF THEN`, err)
+	}
+
+	// Stateful functions are only evaluated in a simulation, but they compile.
+	for _, expr := range []string{"DELAY FIXED([x], 2, 0)", "delay_fixed([x], 2, 0)", "Smooth 3([x], 2)", "RANDOM UNIFORM(0, 1)"} {
+		if _, err := utils.Compile("y", expr, scope); err != nil {
+			t.Errorf("Compile(%q): %v", expr, err)
+		}
+	}
+}
+
+// TestUndefinedReferenceSuggestion checks that the suggestion for an unknown reference is the
+// same at every compile when several names are equally close, and names a subscripted element
+// rather than one of its instances.
+func TestUndefinedReferenceSuggestion(t *testing.T) {
+	scope := &utils.Scope{
+		Slots:      map[string]int{"Post": 0, "Cost": 1, "Most": 2, "Population[North]": 3, "Population[South]": 4},
+		Subscripts: map[string][]string{"Population": {"Region"}},
+		Dimensions: map[string][]string{"Region": {"North", "South"}},
+	}
+	tests := []struct{ expr, want string }{
+		{"[Zost] * 2", "Cost"},
+		{"[Populaton] * 2", "Population"},
+		{"[Populaton][North] * 2", "Population"},
+	}
+	for _, tt := range tests {
+		// Map iteration order varies from one compile to the next.
+		for range 20 {
+			_, err := utils.Compile("y", tt.expr, scope)
+			var undefined *utils.UndefinedReferenceError
+			if !errors.As(err, &undefined) || undefined.Suggestion != tt.want {
+				t.Fatalf("Compile(%q) = %v, want a suggestion of %s", tt.expr, err, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// builtin is a function that expressions can call by name, e.g. MAX([a], 0).
+// maxArgs < 0 means the function takes any number of arguments from minArgs up.
+type builtin struct {
+	minArgs int
+	maxArgs int
+	fn      func(ctx *Context, args []float64) (float64, error)
+}
+
+// builtins is the function library of the expression language, keyed by upper-case name.
+// Names are matched by functionName.
+var builtins = map[string]builtin{
+	"MIN": {2, -1, func(_ *Context, a []float64) (float64, error) {
+		m := a[0]
+		for _, x := range a[1:] {
+			m = math.Min(m, x)
+		}
+		return m, nil
+	}},
+	"MAX": {2, -1, func(_ *Context, a []float64) (float64, error) {
+		m := a[0]
+		for _, x := range a[1:] {
+			m = math.Max(m, x)
+		}
+		return m, nil
+	}},
+	"ABS":  {1, 1, unary(math.Abs)},
+	"SIGN": {1, 1, unary(sign)},
+	"SQRT": {1, 1, func(_ *Context, a []float64) (float64, error) {
+		if a[0] < 0 {
+			return 0, fmt.Errorf("SQRT of negative number %g", a[0])
+		}
+		return math.Sqrt(a[0]), nil
+	}},
+	"EXP": {1, 1, unary(math.Exp)},
+	"LN": {1, 1, func(_ *Context, a []float64) (float64, error) {
+		if a[0] <= 0 {
+			return 0, fmt.Errorf("LN of non-positive number %g", a[0])
+		}
+		return math.Log(a[0]), nil
+	}},
+	// LOG(x) is the base-10 logarithm, LOG(x, base) the logarithm in the given base.
+	"LOG": {1, 2, func(_ *Context, a []float64) (float64, error) {
+		if a[0] <= 0 {
+			return 0, fmt.Errorf("LOG of non-positive number %g", a[0])
+		}
+		if len(a) == 1 {
+			return math.Log10(a[0]), nil
+		}
+		if a[1] <= 0 || a[1] == 1 {
+			return 0, fmt.Errorf("LOG with invalid base %g", a[1])
+		}
+		return math.Log(a[0]) / math.Log(a[1]), nil
+	}},
+	"POW":    {2, 2, func(_ *Context, a []float64) (float64, error) { return math.Pow(a[0], a[1]), nil }},
+	"SIN":    {1, 1, unary(math.Sin)},
+	"COS":    {1, 1, unary(math.Cos)},
+	"TAN":    {1, 1, unary(math.Tan)},
+	"ARCSIN": {1, 1, unary(math.Asin)},
+	"ARCCOS": {1, 1, unary(math.Acos)},
+	"ARCTAN": {1, 1, unary(math.Atan)},
+	"ROUND":  {1, 1, unary(math.Round)},
+	"FLOOR":  {1, 1, unary(math.Floor)},
+	"CEIL":   {1, 1, unary(math.Ceil)},
+	// INTEGER truncates towards zero.
+	"INTEGER": {1, 1, unary(math.Trunc)},
+	// MODULO(a, b) is the remainder of a/b with the sign of b, so MODULO(-1, 5) is 4.
+	"MODULO": {2, 2, func(_ *Context, a []float64) (float64, error) {
+		if a[1] == 0 {
+			return 0, fmt.Errorf("MODULO by zero")
+		}
+		m := math.Mod(a[0], a[1])
+		if m != 0 && (m < 0) != (a[1] < 0) {
+			m += a[1]
+		}
+		return m, nil
+	}},
+	// SAFEDIV(a, b) is a/b, or 0 when b is 0. SAFEDIV(a, b, x) returns x instead of 0.
+	"SAFEDIV": {2, 3, func(_ *Context, a []float64) (float64, error) {
+		if a[1] == 0 {
+			if len(a) == 3 {
+				return a[2], nil
+			}
+			return 0, nil
+		}
+		return a[0] / a[1], nil
+	}},
+	// XIDZ and ZIDZ are the Vensim spellings of SAFEDIV.
+	"XIDZ": {3, 3, func(_ *Context, a []float64) (float64, error) {
+		if a[1] == 0 {
+			return a[2], nil
+		}
+		return a[0] / a[1], nil
+	}},
+	"ZIDZ": {2, 2, func(_ *Context, a []float64) (float64, error) {
+		if a[1] == 0 {
+			return 0, nil
+		}
+		return a[0] / a[1], nil
+	}},
+	"PI": {0, 0, func(*Context, []float64) (float64, error) { return math.Pi, nil }},
+
+	// Test inputs. They read the simulation time from the context, and compare times with a
+	// tolerance of DT/2 so that accumulated rounding in TIME cannot shift an edge by a step.
+
+	// STEP(height, t) is 0 before time t and height from t on.
+	"STEP": {2, 2, func(ctx *Context, a []float64) (float64, error) {
+		if ctx.reached(a[1]) {
+			return a[0], nil
+		}
+		return 0, nil
+	}},
+	// PULSE(start, width) is 1 from start until start+width and 0 otherwise.
+	// A width of 0 gives a pulse lasting a single DT.
+	"PULSE": {2, 2, func(ctx *Context, a []float64) (float64, error) {
+		return boolValue(ctx.inPulse(a[0], a[1])), nil
+	}},
+	// RAMP(slope, start, end) is 0 before start, then rises by slope per time unit until end,
+	// and stays level afterwards. Without end it keeps rising.
+	"RAMP": {2, 3, func(ctx *Context, a []float64) (float64, error) {
+		if !ctx.reached(a[1]) {
+			return 0, nil
+		}
+		t := ctx.Time
+		if len(a) == 3 && ctx.reached(a[2]) {
+			t = a[2]
+		}
+		return a[0] * (t - a[1]), nil
+	}},
+	// PULSE_TRAIN(start, width, interval, end) repeats PULSE(start, width) every interval
+	// time units and is 0 after end.
+	"PULSE_TRAIN": {4, 4, func(ctx *Context, a []float64) (float64, error) {
+		start, width, interval, end := a[0], a[1], a[2], a[3]
+		if interval <= 0 {
+			return 0, fmt.Errorf("PULSE_TRAIN interval must be positive, got %g", interval)
+		}
+		if !ctx.reached(start) || ctx.reached(end+ctx.DT) {
+			return 0, nil
+		}
+		n := math.Floor((ctx.Time - start + ctx.DT/2) / interval)
+		return boolValue(ctx.inPulse(start+n*interval, width)), nil
+	}},
+}
+
+func unary(f func(float64) float64) func(*Context, []float64) (float64, error) {
+	return func(_ *Context, a []float64) (float64, error) { return f(a[0]), nil }
+}
+
+func sign(x float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+// lookupBuiltin finds a function by name and checks that it accepts argc arguments.
+func lookupBuiltin(name string, argc int) (builtin, error) {
+	canonical := functionName(name)
+	b, ok := builtins[canonical]
+	if !ok {
+		return builtin{}, fmt.Errorf("unknown function %s", name)
+	}
+	return b, checkArity(canonical, b.minArgs, b.maxArgs, argc)
+}
+
+// functionNames maps the names of every function, squashed by squashName, to the spelling
+// they are keyed by, e.g. IFTHENELSE to IF_THEN_ELSE.
+var functionNames = map[string]string{}
+
+func init() {
+	add := func(name string) { functionNames[squashName(name)] = name }
+	for name := range builtins {
+		add(name)
+	}
+	for name := range aggregates {
+		add(name)
+	}
+	for name := range statefuls {
+		add(name)
+	}
+	for name := range randoms {
+		add(name)
+	}
+	add(ifThenElse)
+	add(lookupFunc)
+}
+
+// functionName returns the name a function call refers to in the function tables. Function
+// names ignore case, spaces and underscores, so If Then Else, IF THEN ELSE and if_then_else
+// all call IF_THEN_ELSE. A name that is no function is returned in upper case.
+func functionName(name string) string {
+	if canonical, ok := functionNames[squashName(name)]; ok {
+		return canonical
+	}
+	return strings.ToUpper(name)
+}
+
+// squashName returns name in upper case without spaces or underscores.
+func squashName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
+
+// checkArity checks that a function taking minArgs to maxArgs arguments was called with argc.
+// maxArgs < 0 means there is no upper bound.
+func checkArity(name string, minArgs, maxArgs, argc int) error {
+	switch {
+	case minArgs == maxArgs && argc != minArgs:
+		return fmt.Errorf("%s expects %d argument(s), got %d", name, minArgs, argc)
+	case argc < minArgs:
+		return fmt.Errorf("%s expects at least %d arguments, got %d", name, minArgs, argc)
+	case maxArgs >= 0 && argc > maxArgs:
+		return fmt.Errorf("%s expects at most %d arguments, got %d", name, maxArgs, argc)
+	}
+	return nil
+}
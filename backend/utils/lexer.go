@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokName // a bare name, possibly of several words
+	tokRef  // a [name]
+	tokOp   // an operator or punctuation, in its canonical spelling
+)
+
+type lexToken struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  Pos
+}
+
+func (t lexToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokNumber:
+		return "number " + t.text
+	case tokName:
+		return "name " + t.text
+	case tokRef:
+		return "[" + t.text + "]"
+	}
+	return strconv.Quote(t.text)
+}
+
+// keywords are the word operators. They are matched case-insensitively and end a bare name,
+// so a name containing one of them as a word must be written in brackets.
+var keywords = map[string]string{"AND": "AND", "OR": "OR", "NOT": "NOT"}
+
+// operators are the symbolic operators, longest first, with the canonical spelling of the
+// aliases kept for expressions written in Go syntax.
+var operators = []struct{ text, canonical string }{
+	{"<=", "<="}, {">=", ">="}, {"<>", "<>"}, {"==", "="}, {"!=", "<>"}, {"&&", "AND"}, {"||", "OR"},
+	{"+", "+"}, {"-", "-"}, {"*", "*"}, {"/", "/"}, {"^", "^"}, {"=", "="}, {"<", "<"}, {">", ">"},
+	{"!", "NOT"}, {"(", "("}, {")", ")"}, {",", ","}, {":", ":"},
+}
+
+// lexer splits an expression into tokens, tracking the line and column of each.
+type lexer struct {
+	src  []rune
+	i    int
+	line int
+	col  int
+}
+
+func lex(src string) ([]lexToken, error) {
+	l := &lexer{src: []rune(src), line: 1, col: 1}
+	var toks []lexToken
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.kind == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Line: l.line, Column: l.col}
+}
+
+func (l *lexer) peek(k int) rune {
+	if l.i+k < len(l.src) {
+		return l.src[l.i+k]
+	}
+	return 0
+}
+
+func (l *lexer) advance() rune {
+	r := l.src[l.i]
+	l.i++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func (l *lexer) errorf(pos Pos, format string, args ...any) error {
+	return &SyntaxError{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) next() (lexToken, error) {
+	for l.i < len(l.src) && unicode.IsSpace(l.src[l.i]) {
+		l.advance()
+	}
+	pos := l.pos()
+	if l.i >= len(l.src) {
+		return lexToken{kind: tokEOF, pos: pos}, nil
+	}
+	r := l.src[l.i]
+	switch {
+	case r == '[':
+		return l.ref(pos)
+	case unicode.IsDigit(r) || r == '.' && unicode.IsDigit(l.peek(1)):
+		return l.number(pos)
+	case isWordStart(r):
+		return l.name(pos), nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(string(l.src[l.i:min(l.i+2, len(l.src))]), op.text) {
+			for range op.text {
+				l.advance()
+			}
+			return lexToken{kind: tokOp, text: op.canonical, pos: pos}, nil
+		}
+	}
+	return lexToken{}, l.errorf(pos, "unexpected character %q", r)
+}
+
+func (l *lexer) ref(pos Pos) (lexToken, error) {
+	l.advance()
+	start := l.i
+	for l.i < len(l.src) && l.src[l.i] != ']' {
+		l.advance()
+	}
+	if l.i >= len(l.src) {
+		return lexToken{}, l.errorf(pos, "[ is never closed")
+	}
+	name := string(l.src[start:l.i])
+	l.advance()
+	if name == "" {
+		return lexToken{}, l.errorf(pos, "empty reference []")
+	}
+	return lexToken{kind: tokRef, text: name, pos: pos}, nil
+}
+
+func (l *lexer) number(pos Pos) (lexToken, error) {
+	start := l.i
+	for unicode.IsDigit(l.peek(0)) {
+		l.advance()
+	}
+	if l.peek(0) == '.' {
+		l.advance()
+		for unicode.IsDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+	if e := l.peek(0); e == 'e' || e == 'E' {
+		k := 1
+		if s := l.peek(1); s == '+' || s == '-' {
+			k = 2
+		}
+		if unicode.IsDigit(l.peek(k)) {
+			for ; k > 0; k-- {
+				l.advance()
+			}
+			for unicode.IsDigit(l.peek(0)) {
+				l.advance()
+			}
+		}
+	}
+	text := string(l.src[start:l.i])
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return lexToken{}, l.errorf(pos, "invalid number %s", text)
+	}
+	if isWordStart(l.peek(0)) {
+		return lexToken{}, l.errorf(l.pos(), "unexpected %q after number %s", l.peek(0), text)
+	}
+	return lexToken{kind: tokNumber, text: text, num: v, pos: pos}, nil
+}
+
+// name reads a bare name. Words separated by spaces or tabs belong to the same name, so
+// Birth Rate * 2 references the element "Birth Rate". A keyword is a token of its own.
+func (l *lexer) name(pos Pos) lexToken {
+	first := l.word()
+	if op, ok := keywords[strings.ToUpper(first)]; ok {
+		return lexToken{kind: tokOp, text: op, pos: pos}
+	}
+	words := []string{first}
+	for {
+		save, line, col := l.i, l.line, l.col
+		for r := l.peek(0); r == ' ' || r == '\t'; r = l.peek(0) {
+			l.advance()
+		}
+		if l.i == save || !isWordChar(l.peek(0)) {
+			l.i, l.line, l.col = save, line, col
+			break
+		}
+		w := l.word()
+		if _, ok := keywords[strings.ToUpper(w)]; ok {
+			l.i, l.line, l.col = save, line, col
+			break
+		}
+		words = append(words, w)
+	}
+	return lexToken{kind: tokName, text: strings.Join(words, " "), pos: pos}
+}
+
+func (l *lexer) word() string {
+	start := l.i
+	for l.i < len(l.src) && isWordChar(l.src[l.i]) {
+		l.advance()
+	}
+	return string(l.src[start:l.i])
+}
+
+func isWordStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isWordChar(r rune) bool {
+	return isWordStart(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"sort"
+)
+
+// LookupTable is a graphical function evaluated by interpolating between points.
+// X must be strictly increasing and have the same length as Y.
+type LookupTable struct {
+	X []float64
+	Y []float64
+	// Step holds each Y until the next X instead of interpolating linearly.
+	Step bool
+	// Extrapolate continues the first and last segments outside the X range
+	// instead of clamping to the first and last Y.
+	Extrapolate bool
+}
+
+// At returns the value of the table at x.
+func (l *LookupTable) At(x float64) float64 {
+	n := len(l.X)
+	if n == 0 {
+		return 0
+	}
+	if n == 1 {
+		return l.Y[0]
+	}
+	// i is the index of the first point with X > x.
+	i := sort.Search(n, func(i int) bool { return l.X[i] > x })
+	if l.Step {
+		if i == 0 {
+			return l.Y[0]
+		}
+		return l.Y[i-1]
+	}
+	switch {
+	case i == 0 && !l.Extrapolate:
+		return l.Y[0]
+	case i == n && !l.Extrapolate:
+		return l.Y[n-1]
+	case i == 0:
+		i = 1
+	case i == n:
+		i = n - 1
+	}
+	x0, x1, y0, y1 := l.X[i-1], l.X[i], l.Y[i-1], l.Y[i]
+	return y0 + (y1-y0)*(x-x0)/(x1-x0)
+}
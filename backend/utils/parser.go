@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse parses an expression of the modelling language. From loosest to tightest binding:
+//
+//	OR  ||                       logical or
+//	AND &&                       logical and
+//	NOT !                        logical not (prefix)
+//	= == <> != < > <= >=         comparisons
+//	+ -                          addition, subtraction
+//	* /                          multiplication, division
+//	- +                          negation (prefix)
+//	^                            power, grouping from the right
+//	:                            lookup applied to an input, [table]:x
+//
+// Operands are numbers, [name] references, bare names made of words (Birth Rate), function
+// calls (MAX(a, b)), lookup calls ([table](x)) and parenthesised expressions. A reference may
+// be followed by its subscripts in brackets, separated by commas: Population[North, Young].
+// Errors are reported as a *SyntaxError with the line and column of the offending token.
+func Parse(src string) (Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	e, err := p.expr(precLowest)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.unexpected(tok)
+	}
+	return e, nil
+}
+
+// parser is a Pratt parser over the tokens of one expression.
+type parser struct {
+	toks []lexToken
+	i    int
+}
+
+func (p *parser) peek() lexToken {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() lexToken {
+	tok := p.toks[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *parser) isOp(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokOp && tok.text == text
+}
+
+func (p *parser) unexpected(tok lexToken) error {
+	return &SyntaxError{Pos: tok.pos, Message: fmt.Sprintf("unexpected %s", tok)}
+}
+
+// expr parses an expression whose infix operators all bind tighter than minPrec.
+func (p *parser) expr(minPrec int) (Expr, error) {
+	left, err := p.prefix()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		prec, ok := infixPrec[tok.text]
+		if tok.kind != tokOp || !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+		rightPrec := prec
+		if rightAssoc[tok.text] {
+			rightPrec--
+		}
+		right, err := p.expr(rightPrec)
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{At: tok.pos, Op: tok.text, X: left, Y: right}
+	}
+}
+
+func (p *parser) prefix() (Expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return &Number{At: tok.pos, Value: tok.num}, nil
+	case tokRef, tokName:
+		bracketed := tok.kind == tokRef
+		if p.isOp("(") {
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			return &Call{At: tok.pos, Name: tok.text, Bracketed: bracketed, Args: args}, nil
+		}
+		ref := &Ref{At: tok.pos, Name: tok.text, Bracketed: bracketed}
+		if p.peek().kind == tokRef {
+			for _, sub := range strings.Split(p.next().text, ",") {
+				ref.Subscripts = append(ref.Subscripts, strings.TrimSpace(sub))
+			}
+		}
+		return ref, nil
+	case tokOp:
+		switch tok.text {
+		case "-", "+":
+			x, err := p.expr(precUnary)
+			if err != nil {
+				return nil, err
+			}
+			return &Unary{At: tok.pos, Op: tok.text, X: x}, nil
+		case "NOT":
+			x, err := p.expr(precNot)
+			if err != nil {
+				return nil, err
+			}
+			return &Unary{At: tok.pos, Op: tok.text, X: x}, nil
+		case "(":
+			e, err := p.expr(precLowest)
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(")") {
+				return nil, &SyntaxError{Pos: p.peek().pos, Message: fmt.Sprintf("expected ) to close the ( at line %d, column %d, found %s", tok.pos.Line, tok.pos.Column, p.peek())}
+			}
+			p.next()
+			return e, nil
+		}
+	}
+	return nil, p.unexpected(tok)
+}
+
+// args parses a parenthesised, comma separated argument list.
+func (p *parser) args() ([]Expr, error) {
+	open := p.next()
+	var args []Expr
+	if p.isOp(")") {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.expr(precLowest)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		switch {
+		case p.isOp(","):
+			p.next()
+		case p.isOp(")"):
+			p.next()
+			return args, nil
+		default:
+			return nil, &SyntaxError{Pos: p.peek().pos, Message: fmt.Sprintf("expected , or ) in the arguments opened at line %d, column %d, found %s", open.pos.Line, open.pos.Column, p.peek())}
+		}
+	}
+}
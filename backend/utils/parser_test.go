@@ -0,0 +1,183 @@
+package utils_test
+
+import (
+	"SystemDynamicsBackend/utils"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tree prints e fully parenthesised, without positions, so that parses can be compared.
+// Bare names are quoted and bracketed ones kept in brackets.
+func tree(e utils.Expr) string {
+	switch n := e.(type) {
+	case *utils.Number:
+		return strconv.FormatFloat(n.Value, 'g', -1, 64)
+	case *utils.Ref:
+		s := strconv.Quote(n.Name)
+		if n.Bracketed {
+			s = "[" + n.Name + "]"
+		}
+		if len(n.Subscripts) > 0 {
+			s += "[" + strings.Join(n.Subscripts, "|") + "]"
+		}
+		return s
+	case *utils.Unary:
+		return "(" + n.Op + " " + tree(n.X) + ")"
+	case *utils.Binary:
+		return "(" + n.Op + " " + tree(n.X) + " " + tree(n.Y) + ")"
+	case *utils.Call:
+		name := strconv.Quote(n.Name)
+		if n.Bracketed {
+			name = "[" + n.Name + "]"
+		}
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = tree(arg)
+		}
+		return name + "(" + strings.Join(args, " ") + ")"
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct{ in, want string }{
+		// Precedence and grouping.
+		{"-2^2", `(- (^ 2 2))`},
+		{"2^3^2", `(^ 2 (^ 3 2))`},
+		{"NOT a = b", `(NOT (= "a" "b"))`},
+		{"not a or b and c", `(OR (NOT "a") (AND "b" "c"))`},
+		{"1 - 2 - 3", `(- (- 1 2) 3)`},
+		{"1 + 2 * 3", `(+ 1 (* 2 3))`},
+		{"(1 + 2) * 3", `(* (+ 1 2) 3)`},
+		{"-a * b", `(* (- "a") "b")`},
+		{"a < b = c", `(= (< "a" "b") "c")`},
+		{"a == b && c != d || !e", `(OR (AND (= "a" "b") (<> "c" "d")) (NOT "e"))`},
+
+		// Bare names made of several words end at operators and keywords.
+		{"Birth Rate * Population", `(* "Birth Rate" "Population")`},
+		{"Birth Rate and Death Rate", `(AND "Birth Rate" "Death Rate")`},
+		{"Stock 2 / Time to Adjust", `(/ "Stock 2" "Time to Adjust")`},
+		{"NOT Ready", `(NOT "Ready")`},
+		{"[Supply and Demand] + 1", `(+ [Supply and Demand] 1)`},
+		{"Population[North, Young]", `"Population"[North|Young]`},
+		{"MAX(Birth Rate, 0)", `"MAX"("Birth Rate" 0)`},
+
+		// Lookups, applied with : or called.
+		{"[Effect]:x", `(: [Effect] "x")`},
+		{"[Effect]:2^2", `(^ (: [Effect] 2) 2)`},
+		{"-[Effect]:[Stock] * 3", `(* (- (: [Effect] [Stock])) 3)`},
+		{"[Effect]([Stock] / 2)", `[Effect]((/ [Stock] 2))`},
+
+		// Numbers.
+		{"1.5e3 + .5", `(+ 1500 0.5)`},
+	}
+	for _, tt := range tests {
+		e, err := utils.Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if got := tree(e); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in           string
+		line, column int
+		message      string
+	}{
+		{"1 +", 1, 4, "unexpected end of expression"},
+		{"(1 + 2", 1, 7, "expected ) to close the ( at line 1, column 1, found end of expression"},
+		{"1 +\n  * 2", 2, 3, `unexpected "*"`},
+		{"MAX(1 2)", 1, 7, "expected , or ) in the arguments opened at line 1, column 4, found number 2"},
+		{"[Stock", 1, 1, "[ is never closed"},
+		{"2 * []", 1, 5, "empty reference []"},
+		{"2x", 1, 2, `unexpected 'x' after number 2`},
+		{"a $ b", 1, 3, `unexpected character '$'`},
+		{"a AND", 1, 6, "unexpected end of expression"},
+		{"1 2", 1, 3, "unexpected number 2"},
+	}
+	for _, tt := range tests {
+		_, err := utils.Parse(tt.in)
+		var syntax *utils.SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("Parse(%q) = %v, want a *SyntaxError", tt.in, err)
+			continue
+		}
+		if syntax.Line != tt.line || syntax.Column != tt.column || syntax.Message != tt.message {
+			t.Errorf("Parse(%q) error at %d:%d %q, want %d:%d %q", tt.in, syntax.Line, syntax.Column, syntax.Message, tt.line, tt.column, tt.message)
+		}
+	}
+}
+
+// TestFormatRoundTrip checks that formatting a parsed expression and parsing it again gives
+// the same expression, for hand-written expressions and for random ones.
+func TestFormatRoundTrip(t *testing.T) {
+	sources := []string{
+		"-2^2", "(-2)^2", "2^3^2", "(2^3)^2", "NOT a = b", "(NOT a) = b", "1 - (2 - 3)",
+		"a / (b * c)", "-(-a)", "Birth Rate * [Population][North, Young]", "[Effect]:(x + 1)",
+		"IF THEN ELSE([a] > 0 AND NOT [b] <> 1, SMOOTH([c], 2), -[d]^0.5)",
+	}
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 500 {
+		x := randomExpr(r, 4)
+		src := utils.Format(x)
+		if e, err := utils.Parse(src); err != nil || tree(e) != tree(x) {
+			t.Errorf("Parse(Format(%s)) = Parse(%q), which is not the same expression (%v)", tree(x), src, err)
+		}
+		sources = append(sources, src)
+	}
+
+	for _, src := range sources {
+		e, err := utils.Parse(src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", src, err)
+			continue
+		}
+		formatted := utils.Format(e)
+		again, err := utils.Parse(formatted)
+		if err != nil {
+			t.Errorf("Parse(Format(Parse(%q))) = Parse(%q): %v", src, formatted, err)
+			continue
+		}
+		if got, want := tree(again), tree(e); got != want {
+			t.Errorf("Parse(Format(Parse(%q))) = %s, want %s", src, got, want)
+		}
+		if f := utils.Format(again); f != formatted {
+			t.Errorf("Format is not stable for %q: %q, then %q", src, formatted, f)
+		}
+	}
+}
+
+// randomExpr builds a random expression of the given depth.
+func randomExpr(r *rand.Rand, depth int) utils.Expr {
+	names := []string{"a", "Birth Rate", "TIME", "Stock 2"}
+	if depth == 0 || r.IntN(4) == 0 {
+		switch r.IntN(3) {
+		case 0:
+			return &utils.Number{Value: float64(r.IntN(100)) / 4}
+		case 1:
+			return &utils.Ref{Name: names[r.IntN(len(names))]}
+		}
+		return &utils.Ref{Name: "Stock and Flow", Bracketed: true}
+	}
+	switch r.IntN(4) {
+	case 0:
+		return &utils.Unary{Op: []string{"-", "+", "NOT"}[r.IntN(3)], X: randomExpr(r, depth-1)}
+	case 1:
+		args := make([]utils.Expr, r.IntN(3))
+		for i := range args {
+			args[i] = randomExpr(r, depth-1)
+		}
+		return &utils.Call{Name: "MAX", Args: args}
+	}
+	ops := []string{"OR", "AND", "=", "<>", "<", ">=", "+", "-", "*", "/", "^", ":"}
+	return &utils.Binary{Op: ops[r.IntN(len(ops))], X: randomExpr(r, depth-1), Y: randomExpr(r, depth-1)}
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Format prints e in canonical form: operators in their canonical spelling (AND, OR, NOT, =, <>),
+// single spaces around infix operators, and only the parentheses that precedence requires.
+// Parsing the result gives back an expression equal to e.
+func Format(e Expr) string {
+	var b strings.Builder
+	format(&b, e)
+	return b.String()
+}
+
+func format(b *strings.Builder, e Expr) {
+	switch n := e.(type) {
+	case *Number:
+		b.WriteString(strconv.FormatFloat(n.Value, 'g', -1, 64))
+	case *Ref:
+		formatName(b, n.Name, n.Bracketed)
+		if len(n.Subscripts) > 0 {
+			b.WriteString("[" + strings.Join(n.Subscripts, ", ") + "]")
+		}
+	case *Unary:
+		b.WriteString(n.Op)
+		if n.Op == "NOT" {
+			b.WriteByte(' ')
+		}
+		formatOperand(b, n.X, precOf(n), false)
+	case *Binary:
+		prec := precOf(n)
+		formatOperand(b, n.X, prec, rightAssoc[n.Op])
+		if n.Op == ":" || n.Op == "^" {
+			b.WriteString(n.Op)
+		} else {
+			b.WriteString(" " + n.Op + " ")
+		}
+		formatOperand(b, n.Y, prec, !rightAssoc[n.Op])
+	case *Call:
+		formatName(b, n.Name, n.Bracketed)
+		b.WriteByte('(')
+		for i, arg := range n.Args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			format(b, arg)
+		}
+		b.WriteByte(')')
+	}
+}
+
+// formatOperand prints an operand of an operator binding at prec, in parentheses when it binds
+// more loosely, or equally and on the side the operator does not group from.
+func formatOperand(b *strings.Builder, e Expr, prec int, strict bool) {
+	p := precOf(e)
+	if p < prec || strict && p == prec {
+		b.WriteByte('(')
+		format(b, e)
+		b.WriteByte(')')
+		return
+	}
+	format(b, e)
+}
+
+func formatName(b *strings.Builder, name string, bracketed bool) {
+	if bracketed {
+		b.WriteString("[" + name + "]")
+		return
+	}
+	b.WriteString(name)
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// randoms are the stochastic functions, keyed by upper-case name. Like the delays they are
+// called per site: every call in the model draws from its own stream, seeded from the run's
+// seed and the site key, so adding a random call does not change the numbers drawn by the
+// others. A value is drawn once per time step and held for the whole DT.
+var randoms = map[string]stateful{
+	// RANDOM_UNIFORM(min, max) is uniformly distributed in [min, max).
+	"RANDOM_UNIFORM": {2, 2, func(ctx *Context, site string, a []float64) (float64, error) {
+		lo, hi := a[0], a[1]
+		if hi < lo {
+			return 0, fmt.Errorf("RANDOM_UNIFORM max %g is below min %g", hi, lo)
+		}
+		return ctx.States.Random(site, func(_ *Context, r *rand.Rand, a []float64, _ float64, _ bool) float64 {
+			return a[0] + (a[1]-a[0])*r.Float64()
+		}, ctx, a)
+	}},
+	// RANDOM_NORMAL(min, max, mean, sd) is normally distributed and clipped to [min, max].
+	"RANDOM_NORMAL": {4, 4, func(ctx *Context, site string, a []float64) (float64, error) {
+		if a[1] < a[0] || a[3] < 0 {
+			return 0, fmt.Errorf("RANDOM_NORMAL needs min <= max and sd >= 0")
+		}
+		return ctx.States.Random(site, func(_ *Context, r *rand.Rand, a []float64, _ float64, _ bool) float64 {
+			lo, hi, mean, sd := a[0], a[1], a[2], a[3]
+			return math.Max(lo, math.Min(hi, mean+sd*r.NormFloat64()))
+		}, ctx, a)
+	}},
+	// RANDOM_EXPONENTIAL(mean) is exponentially distributed with the given mean.
+	"RANDOM_EXPONENTIAL": {1, 1, func(ctx *Context, site string, a []float64) (float64, error) {
+		mean := a[0]
+		if mean <= 0 {
+			return 0, fmt.Errorf("RANDOM_EXPONENTIAL mean must be positive, got %g", mean)
+		}
+		return ctx.States.Random(site, func(_ *Context, r *rand.Rand, a []float64, _ float64, _ bool) float64 {
+			return a[0] * r.ExpFloat64()
+		}, ctx, a)
+	}},
+	// POISSON(mean) is a Poisson-distributed count, e.g. arrivals per time step.
+	"POISSON": {1, 1, func(ctx *Context, site string, a []float64) (float64, error) {
+		mean := a[0]
+		if mean < 0 {
+			return 0, fmt.Errorf("POISSON mean must not be negative, got %g", mean)
+		}
+		return ctx.States.Random(site, func(_ *Context, r *rand.Rand, a []float64, _ float64, _ bool) float64 {
+			return poisson(r, a[0])
+		}, ctx, a)
+	}},
+	// PINK_NOISE(mean, sd, correlation time) is noise around mean with standard deviation sd
+	// whose successive values are correlated over the correlation time: white noise passed
+	// through first-order smoothing, scaled so that sd does not depend on DT.
+	"PINK_NOISE": {3, 3, func(ctx *Context, site string, a []float64) (float64, error) {
+		if a[1] < 0 || a[2] <= 0 {
+			return 0, fmt.Errorf("PINK_NOISE needs sd >= 0 and a positive correlation time")
+		}
+		deviation, err := ctx.States.Random(site, func(ctx *Context, r *rand.Rand, a []float64, prev float64, first bool) float64 {
+			sd, corr := a[1], a[2]
+			if first {
+				return sd * r.NormFloat64()
+			}
+			alpha := math.Min(1, ctx.DT/corr)
+			white := sd * math.Sqrt((2-alpha)/alpha) * r.NormFloat64()
+			return prev + alpha*(white-prev)
+		}, ctx, a)
+		return a[0] + deviation, err
+	}},
+}
+
+// poisson draws from a Poisson distribution. Knuth's multiplication method is exact and
+// cheap for small means; large means use the normal approximation.
+func poisson(r *rand.Rand, mean float64) float64 {
+	if mean == 0 {
+		return 0
+	}
+	if mean > 30 {
+		return math.Max(0, math.Round(mean+math.Sqrt(mean)*r.NormFloat64()))
+	}
+	limit := math.Exp(-mean)
+	k, p := 0.0, r.Float64()
+	for p > limit {
+		k++
+		p *= r.Float64()
+	}
+	return k
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// UndefinedReferenceError reports a [name] reference to an element that does not exist.
+type UndefinedReferenceError struct {
+	Name       string `json:"name"`
+	Element    string `json:"element"`
+	Kind       string `json:"kind,omitempty"`
+	Expression string `json:"expression"`
+	Line       int    `json:"line"`
+	Column     int    `json:"column"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (e *UndefinedReferenceError) Error() string {
+	element := e.Element
+	if element == "" {
+		element = "expression"
+	}
+	if e.Kind != "" {
+		element = e.Kind + " " + element
+	}
+	msg := fmt.Sprintf("%s references unknown element [%s] at line %d, column %d of %q", element, e.Name, e.Line, e.Column, e.Expression)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf("; did you mean [%s]?", e.Suggestion)
+	}
+	return msg
+}
+
+// Suggest returns the candidate closest to name by edit distance, ignoring case, or ""
+// when none is close enough to be a likely typo. Of equally close candidates, the first
+// is returned.
+func Suggest(name string, candidates []string) string {
+	best, bestDist := "", -1
+	target := strings.ToLower(name)
+	for _, c := range candidates {
+		d := editDistance(target, strings.ToLower(c))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	// Allow about one edit per three characters, and at least two.
+	limit := utf8.RuneCountInString(name) / 3
+	if limit < 2 {
+		limit = 2
+	}
+	if bestDist < 0 || bestDist > limit {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b, counted in characters.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// SubscriptName returns the name of one instance of a subscripted element, e.g.
+// Population[North] or Population[North,Young]. Results are keyed by these names.
+func SubscriptName(name string, subscripts []string) string {
+	return name + "[" + strings.Join(subscripts, ",") + "]"
+}
+
+// Combinations returns every combination of one element from each list, in order, with the
+// last list varying fastest.
+func Combinations(lists [][]string) [][]string {
+	combos := [][]string{{}}
+	for _, list := range lists {
+		next := make([][]string, 0, len(combos)*len(list))
+		for _, combo := range combos {
+			for _, e := range list {
+				next = append(next, append(slices.Clip(combo), e))
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+// SplitEquations splits the equation of a subscripted element at the commas outside parentheses
+// and brackets. An equation of one part applies to every subscript; a list of parts gives each
+// subscript its own equation, in the order of Combinations, e.g. "100, 250, 80".
+func SplitEquations(expr string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range expr {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(expr[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(expr[start:]))
+}
+
+// aggregates are the functions that, called with one argument, combine its values over the
+// dimensions marked with !, e.g. SUM(Population[Region!]).
+var aggregates = map[string]func(values []float64) float64{
+	"SUM": func(v []float64) float64 {
+		s := 0.0
+		for _, x := range v {
+			s += x
+		}
+		return s
+	},
+	"MEAN": func(v []float64) float64 {
+		s := 0.0
+		for _, x := range v {
+			s += x
+		}
+		return s / float64(len(v))
+	},
+	"MIN": slices.Min[[]float64],
+	"MAX": slices.Max[[]float64],
+}
+
+// binding returns the subscripts of the instance element stands for, keyed by dimension.
+// It is empty for an element that is not subscripted.
+func (s *Scope) binding(element string) map[string]string {
+	binding := map[string]string{}
+	open := strings.IndexByte(element, '[')
+	if open < 0 || !strings.HasSuffix(element, "]") {
+		return binding
+	}
+	dims, ok := s.Subscripts[element[:open]]
+	subs := strings.Split(element[open+1:len(element)-1], ",")
+	if !ok || len(subs) != len(dims) {
+		return binding
+	}
+	for i, dim := range dims {
+		binding[dim] = subs[i]
+	}
+	return binding
+}
+
+// resolve returns the slot name that ref reads in the instance being compiled. A subscript
+// naming a dimension takes the instance's element of it, one marked with ! the element an
+// enclosing aggregate is at, and any other subscript must be an element of its dimension.
+// A subscripted element referenced without subscripts takes the instance's own.
+func (c *compiler) resolve(ref *Ref) (string, error) {
+	dims, subscripted := c.scope.Subscripts[ref.Name]
+	if !subscripted {
+		if _, ok := c.scope.Slots[ref.Name]; ok && len(ref.Subscripts) > 0 {
+			return "", fmt.Errorf("%s has no subscripts", ref.Name)
+		}
+		return ref.Name, nil
+	}
+	subs := ref.Subscripts
+	if len(subs) == 0 {
+		subs = dims
+	}
+	if len(subs) != len(dims) {
+		return "", fmt.Errorf("%s is subscripted by %s, got %d subscript(s)", ref.Name, strings.Join(dims, ", "), len(subs))
+	}
+	picked := make([]string, len(dims))
+	for i, sub := range subs {
+		dim := dims[i]
+		switch {
+		case sub == dim || sub == dim+"!":
+			v, ok := c.binding[sub]
+			if !ok && sub == dim {
+				example := dim
+				if elems := c.scope.Dimensions[dim]; len(elems) > 0 {
+					example = elems[0]
+				}
+				return "", fmt.Errorf("%s needs a subscript for %s here: pick an element, e.g. %s[%s], or combine them, e.g. SUM(%s[%s!])", ref.Name, dim, ref.Name, example, ref.Name, dim)
+			}
+			if !ok {
+				return "", fmt.Errorf("%s[%s] can only be used inside SUM, MEAN, MIN or MAX", ref.Name, sub)
+			}
+			picked[i] = v
+		case slices.Contains(c.scope.Dimensions[dim], sub):
+			picked[i] = sub
+		default:
+			return "", fmt.Errorf("%s is not an element of dimension %s, the dimension of subscript %d of %s", sub, dim, i+1, ref.Name)
+		}
+	}
+	return SubscriptName(ref.Name, picked), nil
+}
+
+// compileAggregate compiles SUM, MEAN, MIN or MAX of one argument: the argument is compiled once
+// for every combination of the elements of its dimensions marked with !.
+func (c *compiler) compileAggregate(n *Call, combine func([]float64) float64) (evalFunc, error) {
+	upper := functionName(n.Name)
+	var starred []string
+	Walk(n.Args[0], func(e Expr) {
+		if ref, ok := e.(*Ref); ok {
+			for _, sub := range ref.Subscripts {
+				if dim, ok := strings.CutSuffix(sub, "!"); ok && !slices.Contains(starred, dim) {
+					starred = append(starred, dim)
+				}
+			}
+		}
+	})
+	if len(starred) == 0 {
+		return nil, fmt.Errorf("%s of one argument combines the elements of a dimension marked with !, e.g. %s(Population[Region!])", upper, upper)
+	}
+	lists := make([][]string, len(starred))
+	for i, dim := range starred {
+		elems, ok := c.scope.Dimensions[dim]
+		if !ok {
+			return nil, fmt.Errorf("unknown dimension %s", dim)
+		}
+		lists[i] = elems
+	}
+
+	outer := c.binding
+	defer func() { c.binding = outer }()
+	var terms []evalFunc
+	for _, combo := range Combinations(lists) {
+		c.binding = maps.Clone(outer)
+		for i, dim := range starred {
+			c.binding[dim+"!"] = combo[i]
+		}
+		term, err := c.compile(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	values := make([]float64, len(terms))
+	return func(ctx *Context) (float64, error) {
+		for i, term := range terms {
+			var err error
+			if values[i], err = term(ctx); err != nil {
+				return 0, err
+			}
+		}
+		return combine(values), nil
+	}, nil
+}
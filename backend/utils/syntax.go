@@ -0,0 +1,136 @@
+package utils
+
+import "fmt"
+
+// Pos is a position in the source of an expression: a 1-based line and column, counted in characters.
+type Pos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Expr is a node of a parsed expression.
+type Expr interface {
+	Pos() Pos
+}
+
+// Number is a numeric literal.
+type Number struct {
+	At    Pos
+	Value float64
+}
+
+// Ref is a reference to an element or lookup, written [name] or, when the name is made of
+// words, bare. Bare names also stand for the clock, e.g. TIME. A reference to a subscripted
+// element may pick its subscripts, e.g. Population[North] or [Population][Region!].
+type Ref struct {
+	At         Pos
+	Name       string
+	Bracketed  bool
+	Subscripts []string
+}
+
+// Unary is a prefix operator: -, + or NOT.
+type Unary struct {
+	At Pos
+	Op string
+	X  Expr
+}
+
+// Binary is an infix operator. Op is the canonical spelling: + - * / ^ = <> < > <= >= AND OR,
+// or : for a lookup applied to an input, [table]:x.
+type Binary struct {
+	At Pos // of the operator
+	Op string
+	X  Expr
+	Y  Expr
+}
+
+// Call is a function call, e.g. MAX(a, b), or a lookup called with an input, e.g. [table](x).
+type Call struct {
+	At        Pos
+	Name      string
+	Bracketed bool
+	Args      []Expr
+}
+
+func (n *Number) Pos() Pos { return n.At }
+func (n *Ref) Pos() Pos    { return n.At }
+func (n *Unary) Pos() Pos  { return n.At }
+func (n *Binary) Pos() Pos { return n.At }
+func (n *Call) Pos() Pos   { return n.At }
+
+// SyntaxError reports an expression that cannot be parsed.
+type SyntaxError struct {
+	Pos
+	Message string `json:"message"`
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// Binding powers of the operators, from loosest to tightest. A prefix operator parses its
+// operand at its own power, so NOT [a] = [b] is NOT ([a] = [b]) and -2^2 is -(2^2).
+const (
+	precLowest = iota
+	precOr
+	precAnd
+	precNot
+	precCompare
+	precAdd
+	precMul
+	precUnary
+	precPower
+	precLookup
+	precPrimary
+)
+
+var infixPrec = map[string]int{
+	"OR":  precOr,
+	"AND": precAnd,
+	"=":   precCompare,
+	"<>":  precCompare,
+	"<":   precCompare,
+	">":   precCompare,
+	"<=":  precCompare,
+	">=":  precCompare,
+	"+":   precAdd,
+	"-":   precAdd,
+	"*":   precMul,
+	"/":   precMul,
+	"^":   precPower,
+	":":   precLookup,
+}
+
+// rightAssoc holds the operators that group from the right: 2^3^2 is 2^(3^2).
+var rightAssoc = map[string]bool{"^": true}
+
+// precOf returns the binding power of the operator at the root of e.
+func precOf(e Expr) int {
+	switch n := e.(type) {
+	case *Unary:
+		if n.Op == "NOT" {
+			return precNot
+		}
+		return precUnary
+	case *Binary:
+		return infixPrec[n.Op]
+	}
+	return precPrimary
+}
+
+// Walk calls fn for e and then for each of its operands and arguments, depth first.
+func Walk(e Expr, fn func(Expr)) {
+	fn(e)
+	switch n := e.(type) {
+	case *Unary:
+		Walk(n.X, fn)
+	case *Binary:
+		Walk(n.X, fn)
+		Walk(n.Y, fn)
+	case *Call:
+		for _, arg := range n.Args {
+			Walk(arg, fn)
+		}
+	}
+}
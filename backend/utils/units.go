@@ -0,0 +1,481 @@
+package utils
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Unit is a unit of measure given by the exponents of its base units: people/year is
+// {"people": 1, "year": -1}. The empty Unit is dimensionless. Base units are only compared
+// by name, so there are no conversions between e.g. month and year.
+type Unit map[string]int
+
+// ParseUnit parses a unit such as people/year, $/widget, kg*m/s^2 or 1/(person*year).
+// Base units are words, which may include $ and %. Units are multiplied with * or a space
+// and divided with / or per; a / divides by the next factor only, so a/b/c is a/(b*c).
+// "", "1" and "dimensionless" are dimensionless.
+func ParseUnit(s string) (Unit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "dimensionless") {
+		return Unit{}, nil
+	}
+	p := &unitParser{src: []rune(s)}
+	u, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.i < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q in unit %q", p.src[p.i], s)
+	}
+	return u, nil
+}
+
+type unitParser struct {
+	src []rune
+	i   int
+}
+
+func (p *unitParser) skipSpace() {
+	for p.i < len(p.src) && unicode.IsSpace(p.src[p.i]) {
+		p.i++
+	}
+}
+
+func isUnitRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune("*/^()·", r)
+}
+
+// product parses factors joined by *, /, per or spaces.
+func (p *unitParser) product() (Unit, error) {
+	u, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if p.i >= len(p.src) || p.src[p.i] == ')' {
+			return u, nil
+		}
+		divide := false
+		switch {
+		case p.src[p.i] == '*' || p.src[p.i] == '·':
+			p.i++
+		case p.src[p.i] == '/':
+			divide = true
+			p.i++
+		case p.word() == "per":
+			divide = true
+			p.i += 3
+		}
+		v, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		if divide {
+			v = v.Pow(-1)
+		}
+		u = u.Mul(v)
+	}
+}
+
+// word returns the word at the current position without consuming it.
+func (p *unitParser) word() string {
+	j := p.i
+	for j < len(p.src) && isUnitRune(p.src[j]) {
+		j++
+	}
+	return string(p.src[p.i:j])
+}
+
+// factor parses a base unit or a parenthesised unit, optionally raised to an integer power.
+func (p *unitParser) factor() (Unit, error) {
+	p.skipSpace()
+	var u Unit
+	switch w := p.word(); {
+	case p.i < len(p.src) && p.src[p.i] == '(':
+		p.i++
+		inner, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		if p.i >= len(p.src) || p.src[p.i] != ')' {
+			return nil, fmt.Errorf("missing ) in unit %q", string(p.src))
+		}
+		p.i++
+		u = inner
+	case w == "":
+		if p.i >= len(p.src) {
+			return nil, fmt.Errorf("unit %q ends with an operator", string(p.src))
+		}
+		return nil, fmt.Errorf("unexpected %q in unit %q", p.src[p.i], string(p.src))
+	case w == "1":
+		p.i += len([]rune(w))
+		u = Unit{}
+	default:
+		p.i += len([]rune(w))
+		u = Unit{w: 1}
+	}
+	p.skipSpace()
+	if p.i < len(p.src) && p.src[p.i] == '^' {
+		p.i++
+		p.skipSpace()
+		start := p.i
+		if p.i < len(p.src) && p.src[p.i] == '-' {
+			p.i++
+		}
+		for p.i < len(p.src) && unicode.IsDigit(p.src[p.i]) {
+			p.i++
+		}
+		n, err := strconv.Atoi(string(p.src[start:p.i]))
+		if err != nil {
+			return nil, fmt.Errorf("the power in unit %q must be an integer", string(p.src))
+		}
+		u = u.Pow(n)
+	}
+	return u, nil
+}
+
+// Mul returns u * v.
+func (u Unit) Mul(v Unit) Unit {
+	out := maps.Clone(u)
+	if out == nil {
+		out = Unit{}
+	}
+	for name, n := range v {
+		if out[name] += n; out[name] == 0 {
+			delete(out, name)
+		}
+	}
+	return out
+}
+
+// Div returns u / v.
+func (u Unit) Div(v Unit) Unit {
+	return u.Mul(v.Pow(-1))
+}
+
+// Pow returns u raised to the power n.
+func (u Unit) Pow(n int) Unit {
+	out := Unit{}
+	if n == 0 {
+		return out
+	}
+	for name, e := range u {
+		out[name] = e * n
+	}
+	return out
+}
+
+// Equal reports whether u and v are the same unit.
+func (u Unit) Equal(v Unit) bool {
+	return maps.Equal(u, v)
+}
+
+// String prints u in canonical form, e.g. people/year, kg*m/s^2, 1/(person*year) or dimensionless.
+func (u Unit) String() string {
+	if len(u) == 0 {
+		return "dimensionless"
+	}
+	var num, den []string
+	for _, name := range slices.Sorted(maps.Keys(u)) {
+		n := u[name]
+		term := name
+		if abs := max(n, -n); abs != 1 {
+			term += "^" + strconv.Itoa(abs)
+		}
+		if n > 0 {
+			num = append(num, term)
+		} else {
+			den = append(den, term)
+		}
+	}
+	s := strings.Join(num, "*")
+	if s == "" {
+		s = "1"
+	}
+	switch len(den) {
+	case 0:
+		return s
+	case 1:
+		return s + "/" + den[0]
+	}
+	return s + "/(" + strings.Join(den, "*") + ")"
+}
+
+// UnitIssue is an inconsistency found while inferring the units of an expression.
+type UnitIssue struct {
+	Pos
+	Message string
+}
+
+// UnitEnv is what unit inference knows about a model: the declared units of its elements
+// and the unit of time. Elements without units, and lookups, are of unknown units, and
+// nothing that depends on them is checked.
+type UnitEnv struct {
+	Units func(name string) (Unit, bool)
+	// Time is the time unit, or nil when it is not known.
+	Time Unit
+}
+
+// inferred is the unit of a subexpression. A numeric literal is dimensionless, but takes the
+// unit of the other operand of +, - and comparisons, so [Population] + 1 is consistent.
+type inferred struct {
+	unit    Unit
+	known   bool
+	literal bool
+}
+
+var (
+	unknownUnit   = inferred{}
+	dimensionless = inferred{unit: Unit{}, known: true}
+)
+
+// InferUnits returns the unit of e and the inconsistencies found on the way. ok is false
+// when the unit cannot be known because it depends on an element without units, and when
+// e is only numbers, e.g. a constant, which are in whatever units its element is in.
+func (env *UnitEnv) InferUnits(e Expr) (u Unit, ok bool, issues []UnitIssue) {
+	w := &unitWalker{env: env}
+	r := w.infer(e)
+	return r.unit, r.known && !r.literal, w.issues
+}
+
+type unitWalker struct {
+	env    *UnitEnv
+	issues []UnitIssue
+}
+
+func (w *unitWalker) report(at Pos, format string, args ...any) {
+	w.issues = append(w.issues, UnitIssue{Pos: at, Message: fmt.Sprintf(format, args...)})
+}
+
+func (w *unitWalker) infer(e Expr) inferred {
+	switch n := e.(type) {
+	case *Number:
+		return inferred{unit: Unit{}, known: true, literal: true}
+	case *Ref:
+		if !n.Bracketed && len(n.Subscripts) == 0 {
+			switch strings.ToUpper(n.Name) {
+			case "TIME", "DT", "TIME_STEP", "INITIAL_TIME", "FINAL_TIME":
+				return w.time()
+			}
+		}
+		if u, ok := w.env.Units(n.Name); ok {
+			return inferred{unit: u, known: true}
+		}
+		return unknownUnit
+	case *Unary:
+		x := w.infer(n.X)
+		if n.Op == "NOT" {
+			return dimensionless
+		}
+		return x
+	case *Binary:
+		return w.binary(n)
+	case *Call:
+		return w.call(n)
+	}
+	return unknownUnit
+}
+
+func (w *unitWalker) time() inferred {
+	if w.env.Time == nil {
+		return unknownUnit
+	}
+	return inferred{unit: w.env.Time, known: true}
+}
+
+// same checks that a and b are in the same unit, for the operation what, and returns it.
+func (w *unitWalker) same(at Pos, what string, a, b inferred) inferred {
+	switch {
+	case a.literal:
+		return b
+	case b.literal:
+		return a
+	case !a.known || !b.known:
+		return unknownUnit
+	case !a.unit.Equal(b.unit):
+		w.report(at, "cannot %s %s and %s", what, a.unit, b.unit)
+	}
+	return a
+}
+
+// expect checks that x is in unit want, for the argument what.
+func (w *unitWalker) expect(at Pos, what string, x, want inferred) {
+	switch {
+	case !x.known || x.literal || !want.known || x.unit.Equal(want.unit):
+	case len(want.unit) == 0:
+		w.report(at, "%s should be dimensionless, not in %s", what, x.unit)
+	default:
+		w.report(at, "%s should be in %s, not %s", what, want.unit, x.unit)
+	}
+}
+
+func (w *unitWalker) binary(n *Binary) inferred {
+	if n.Op == ":" {
+		w.infer(n.Y)
+		return unknownUnit
+	}
+	x, y := w.infer(n.X), w.infer(n.Y)
+	switch n.Op {
+	case "+":
+		return w.same(n.At, "add", x, y)
+	case "-":
+		return w.same(n.At, "subtract", x, y)
+	case "=", "<>", "<", ">", "<=", ">=":
+		w.same(n.At, "compare", x, y)
+		return dimensionless
+	case "AND", "OR":
+		return dimensionless
+	case "*", "/":
+		if !x.known || !y.known {
+			return unknownUnit
+		}
+		if n.Op == "/" {
+			y.unit = y.unit.Pow(-1)
+		}
+		return inferred{unit: x.unit.Mul(y.unit), known: true, literal: x.literal && y.literal}
+	case "^":
+		return w.power(n.At, x, n.Y, y)
+	}
+	return unknownUnit
+}
+
+// power is the unit of x raised to the exponent e, whose unit is y.
+func (w *unitWalker) power(at Pos, x inferred, e Expr, y inferred) inferred {
+	w.expect(at, "an exponent", y, dimensionless)
+	if !x.known || x.literal || len(x.unit) == 0 {
+		return x
+	}
+	if num, ok := e.(*Number); ok && num.Value == float64(int(num.Value)) {
+		return inferred{unit: x.unit.Pow(int(num.Value)), known: true}
+	}
+	w.report(at, "%s can only be raised to a constant integer power", x.unit)
+	return unknownUnit
+}
+
+// call infers the unit of a function call from the units of its arguments.
+func (w *unitWalker) call(n *Call) inferred {
+	args := make([]inferred, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = w.infer(arg)
+	}
+	if n.Bracketed {
+		return unknownUnit // a lookup
+	}
+	at := func(i int) Pos { return n.Args[i].Pos() }
+	name := functionName(n.Name)
+	all := func() inferred {
+		r := args[0]
+		for i := 1; i < len(args); i++ {
+			r = w.same(at(i), "combine", r, args[i])
+		}
+		return r
+	}
+	timeArgs := func(what string, idx ...int) {
+		for _, i := range idx {
+			if i < len(args) {
+				w.expect(at(i), what, args[i], w.time())
+			}
+		}
+	}
+	if len(args) == 0 {
+		return dimensionless // PI()
+	}
+	switch name {
+	case "MIN", "MAX", "SUM", "MEAN", "ABS", "ROUND", "FLOOR", "CEIL", "INTEGER", "MODULO":
+		return all()
+	case "SIGN":
+		return dimensionless
+	case "PULSE", "PULSE_TRAIN":
+		timeArgs("a time", 0, 1, 2, 3)
+		return dimensionless
+	case "SQRT":
+		x := args[0]
+		if !x.known || x.literal {
+			return x
+		}
+		half := Unit{}
+		for base, e := range x.unit {
+			if e%2 != 0 {
+				w.report(n.At, "the square root of %s has no unit", x.unit)
+				return unknownUnit
+			}
+			half[base] = e / 2
+		}
+		return inferred{unit: half, known: true}
+	case "EXP", "LN", "LOG", "SIN", "COS", "TAN", "ARCSIN", "ARCCOS", "ARCTAN":
+		for i := range args {
+			w.expect(at(i), "the argument of "+name, args[i], dimensionless)
+		}
+		return dimensionless
+	case "POW":
+		if len(args) == 2 {
+			return w.power(n.At, args[0], n.Args[1], args[1])
+		}
+	case "SAFEDIV", "XIDZ", "ZIDZ":
+		if len(args) < 2 || !args[0].known || !args[1].known {
+			return unknownUnit
+		}
+		q := inferred{unit: args[0].unit.Div(args[1].unit), known: true, literal: args[0].literal && args[1].literal}
+		if len(args) == 3 {
+			return w.same(at(2), "combine", q, args[2])
+		}
+		return q
+	case "IF_THEN_ELSE":
+		if len(args) == 3 {
+			return w.same(at(2), "choose between", args[1], args[2])
+		}
+	case "STEP":
+		timeArgs("a time", 1)
+		return args[0]
+	case "RAMP":
+		timeArgs("a time", 1, 2)
+		if !args[0].known || w.env.Time == nil {
+			return unknownUnit
+		}
+		return inferred{unit: args[0].unit.Mul(w.env.Time), known: true}
+	case "DELAY1", "DELAY1I", "DELAY3", "DELAY3I", "SMOOTH", "SMOOTHI", "SMOOTH3", "SMOOTH3I":
+		timeArgs("a delay or smoothing time", 1)
+		if len(args) == 3 {
+			return w.same(at(2), "combine", args[0], args[2])
+		}
+		return args[0]
+	case "DELAYN":
+		timeArgs("a delay time", 1)
+		if len(args) >= 3 {
+			w.expect(at(2), "the order of DELAYN", args[2], dimensionless)
+		}
+		if len(args) == 4 {
+			return w.same(at(3), "combine", args[0], args[3])
+		}
+		return args[0]
+	case "DELAY_FIXED":
+		timeArgs("a delay time", 1)
+		if len(args) == 3 {
+			return w.same(at(2), "combine", args[0], args[2])
+		}
+		return args[0]
+	case "TREND":
+		timeArgs("an averaging time", 1)
+		if w.env.Time == nil {
+			return unknownUnit
+		}
+		trend := inferred{unit: w.env.Time.Pow(-1), known: true}
+		if len(args) == 3 {
+			w.expect(at(2), "the initial trend", args[2], trend)
+		}
+		return trend
+	case "RANDOM_UNIFORM", "RANDOM_NORMAL":
+		return all()
+	case "RANDOM_EXPONENTIAL", "POISSON":
+		return args[0]
+	case "PINK_NOISE":
+		timeArgs("a correlation time", 2)
+		return w.same(at(1), "combine", args[0], args[1])
+	}
+	return unknownUnit
+}
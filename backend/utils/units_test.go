@@ -0,0 +1,65 @@
+package utils_test
+
+import (
+	"SystemDynamicsBackend/utils"
+	"testing"
+)
+
+func TestParseUnit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want utils.Unit
+		str  string
+	}{
+		{"people/year", utils.Unit{"people": 1, "year": -1}, "people/year"},
+		{"$/widget", utils.Unit{"$": 1, "widget": -1}, "$/widget"},
+		{"1/(person*year)", utils.Unit{"person": -1, "year": -1}, "1/(person*year)"},
+		{"a/b/c", utils.Unit{"a": 1, "b": -1, "c": -1}, "a/(b*c)"},
+		{"people per year", utils.Unit{"people": 1, "year": -1}, "people/year"},
+		{"kg*m/s^2", utils.Unit{"kg": 1, "m": 1, "s": -2}, "kg*m/s^2"},
+		{"widget/widget", utils.Unit{}, "dimensionless"},
+		{"", utils.Unit{}, "dimensionless"},
+		{"1", utils.Unit{}, "dimensionless"},
+		{"dimensionless", utils.Unit{}, "dimensionless"},
+	}
+	for _, tt := range tests {
+		got, err := utils.ParseUnit(tt.in)
+		if err != nil {
+			t.Errorf("ParseUnit(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseUnit(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.str {
+			t.Errorf("ParseUnit(%q).String() = %q, want %q", tt.in, s, tt.str)
+		}
+	}
+}
+
+func TestParseUnitErrors(t *testing.T) {
+	for _, in := range []string{"people/", "(year", "a^x", "*b"} {
+		if u, err := utils.ParseUnit(in); err == nil {
+			t.Errorf("ParseUnit(%q) = %v, want an error", in, u)
+		}
+	}
+}
+
+// TestInferUnitsFunctionNames checks that functions are recognised by unit inference however
+// their names are spelled.
+func TestInferUnitsFunctionNames(t *testing.T) {
+	units := map[string]utils.Unit{"Population": {"people": 1}, "Cost": {"$": 1}}
+	env := &utils.UnitEnv{Units: func(name string) (utils.Unit, bool) {
+		u, ok := units[name]
+		return u, ok
+	}}
+	for _, expr := range []string{"IF_THEN_ELSE(1, [Population], [Cost])", "IF THEN ELSE(1, [Population], [Cost])", "if then else(1, [Population], [Cost])"} {
+		e, err := utils.Parse(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, issues := env.InferUnits(e); len(issues) != 1 || issues[0].Message != "cannot choose between people and $" {
+			t.Errorf("InferUnits(%q) issues %v, want cannot choose between people and $", expr, issues)
+		}
+	}
+}
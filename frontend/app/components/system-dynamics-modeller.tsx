@@ -138,7 +138,7 @@ function SystemDynamicsFlow({ project, onBack }: SystemDynamicsModellerProps) {
           position: { x: 0, y: 0 },
           data: { name: v.name, value: v.value, label: v.name },
         }))
-        const flowsRes = await fetch(`${API_URL}/flows`)
+        const flowsRes = await fetch(`${API_URL}/flows?project_id=${project.id}`)
         const flowsJson = await flowsRes.json()
         const stockIds = new Set(stocks.map((s) => parseInt(s.id.split('-')[1])))
         const flowEdges: Edge[] = (flowsJson.data || [])
@@ -158,7 +158,7 @@ function SystemDynamicsFlow({ project, onBack }: SystemDynamicsModellerProps) {
             id: `flow-rate-${f.id}`,
             type: 'flowRate',
             position: { x: 0, y: 0 },
-            data: { name: `Flow Rate ${f.id}`, flow_value: f.name, label: `Rate ${f.id}` },
+            data: { name: f.name, flow_value: f.equation, label: f.name },
             draggable: true,
           }))
 
@@ -193,9 +193,10 @@ function SystemDynamicsFlow({ project, onBack }: SystemDynamicsModellerProps) {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({
-              name: '1',
+              equation: '1',
               from_stock: Number(params.source?.split('-')[1]),
               to_stock: Number(params.target?.split('-')[1]),
+              project_id: Number(project.id),
             }),
           })
           const data = await res.json()
@@ -205,7 +206,7 @@ function SystemDynamicsFlow({ project, onBack }: SystemDynamicsModellerProps) {
             id: `flow-rate-${id}`,
             type: 'flowRate',
             position: midpoint,
-            data: { name: `Flow Rate ${id}`, flow_value: '1', label: `Rate ${id}` },
+            data: { name: data.data?.name, flow_value: data.data?.equation, label: data.data?.name },
             draggable: true,
           }
 
@@ -231,7 +232,7 @@ function SystemDynamicsFlow({ project, onBack }: SystemDynamicsModellerProps) {
         setEdges((eds) => addEdge(newEdge, eds))
       }
     },
-    [setEdges, setNodes, connectionMode, nodes],
+    [setEdges, setNodes, connectionMode, nodes, project.id],
   )
 
   const onDragOver = useCallback((event: DragEvent) => {
@@ -519,7 +520,7 @@ function SystemDynamicsFlow({ project, onBack }: SystemDynamicsModellerProps) {
             await fetch(`${API_URL}/flows/${fid}`, {
               method: 'PUT',
               headers: { 'Content-Type': 'application/json' },
-              body: JSON.stringify({ name: value }),
+              body: JSON.stringify(property === 'flow_value' ? { equation: value } : { name: value }),
             })
           }
         }